@@ -0,0 +1,83 @@
+
+package main
+
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ocean-of-serenity/gravsim/nbody"
+)
+
+
+const (
+	G = 1.142602313e-4		// Lunar Masses, Solar Radii and days
+	deltaT = 1.0
+	numFrames = 1000
+
+	softeningLength = 1.0
+)
+
+
+var integratorNames = flag.String("integrators", "euler,heun,verlet", "comma separated integrators to measure")
+var numOrbs = flag.Int("orbs", 256, "number of orbs of the disk")
+var numLevels = flag.Int("levels", 5, "number of step sizes, each half the previous one")
+var analytic = flag.Bool("analytic", false, "measure a circular two body orbit against its analytic solution instead of the disk against its finest run")
+var seed = flag.Int64("seed", 1, "seed of the initial conditions")
+
+
+// runs the initial conditions of the accuracy programs for numFrames frames of deltaT with deltaT,
+// deltaT/2, deltaT/4 and so on on the CPU and reports the fitted global order of every integrator.
+func main() {
+	flag.Parse()
+	profilingFileName := fmt.Sprintf("accuracy-convergence-%s.csv", time.Now().Format("2006_01_02_15_04_05"))
+
+	duration := deltaT * numFrames
+	system := &nbody.System{
+		Law: nbody.Newtonian{G: G},
+		Kernel: nbody.PlummerSoftening,
+		Softening: softeningLength,
+	}
+	var reference []nbody.Body
+	if *analytic {
+		orbit := nbody.CircularOrbit{G: G, CentralMass: 1e11, Mass: 1, Radius: 10000}
+		system.Bodies = orbit.Bodies(0)
+		system.Kernel = nbody.NoSoftening
+		reference = orbit.Bodies(duration)
+	} else {
+		system.Bodies = nbody.NewDisk(*numOrbs, 0, G, rand.New(rand.NewSource(*seed)))
+	}
+
+	file, err := os.Create(profilingFileName)
+	if err != nil {
+		log.Fatalln("Could not create", profilingFileName, err)
+	}
+	defer file.Close()
+	fmt.Fprintln(file, "integrator, step_size, steps, position_error, order")
+
+	for _, name := range strings.Split(*integratorNames, ",") {
+		name = strings.TrimSpace(name)
+		if _, err := nbody.NewIntegrator(name); err != nil {
+			log.Fatalln(err)
+		}
+		newIntegrator := func() nbody.Integrator {
+			integrator, _ := nbody.NewIntegrator(name)
+			return integrator
+		}
+
+		c := nbody.MeasureConvergence(system, newIntegrator, duration, numFrames, *numLevels, reference)
+		fmt.Printf("%s: order %.3f\n", c.Integrator, c.Order)
+		for _, level := range c.Levels {
+			fmt.Printf("\tdt %.4g: %.6e\n", level.StepSize, level.Error)
+			_, err = fmt.Fprintf(file, "%s, %v, %v, %v, %v\n", c.Integrator, level.StepSize, level.Steps, level.Error, c.Order)
+			if err != nil {
+				log.Fatalln("Could not write to", profilingFileName, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+
+package main
+
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/ocean-of-serenity/gravsim/nbody"
+)
+
+
+const (
+	G = 1.142602313e-4		// Lunar Masses, Solar Radii and days
+)
+
+
+var outputPrefix = flag.String("output", "accuracy-elements", "prefix of the CSV files written")
+var numBins = flag.Int("bins", 32, "number of histogram bins")
+var maxSemiMajorAxis = flag.Float64("max-semi-major-axis", 44000, "upper end of the semi-major axis histogram, in solar radii")
+
+
+// converts the orbs of a series of snapshots, as written by the accuracy programs every snapshotInterval frames,
+// into Keplerian elements relative to the central orb at index 0. It writes the elements of every orb over time,
+// histograms of semi-major axis, eccentricity and inclination per snapshot, and counts the orbs that got unbound.
+//
+// usage: elements [flags] snapshot.csv...
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatalln("No snapshots given")
+	}
+
+	elementsFileName := *outputPrefix + "-timeseries.csv"
+	elementsFile, err := os.Create(elementsFileName)
+	if err != nil {
+		log.Fatalln("Could not create", elementsFileName, err)
+	}
+	defer elementsFile.Close()
+	fmt.Fprintln(elementsFile, "snapshot, time, orb, semi_major_axis, eccentricity, inclination, periapsis, energy, bound")
+
+	histogramFileName := *outputPrefix + "-histograms.csv"
+	histogramFile, err := os.Create(histogramFileName)
+	if err != nil {
+		log.Fatalln("Could not create", histogramFileName, err)
+	}
+	defer histogramFile.Close()
+	fmt.Fprintln(histogramFile, "snapshot, time, quantity, bin_low, bin_high, count")
+
+	// the inclinations are measured against the disk plane of the first snapshot
+	var normal nbody.Vec3
+	var initiallyBound []bool
+	for k, fileName := range flag.Args() {
+		orbs, metadata, err := nbody.ReadSnapshot(fileName)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		g := G
+		if value, err := strconv.ParseFloat(metadata["G"], 64); err == nil {
+			g = value
+		}
+		time := metadata["time"]
+		if time == "" {
+			time = fmt.Sprint(k)
+		}
+
+		if k == 0 {
+			normal = nbody.OrbitalAngularMomentum(orbs)
+			initiallyBound = make([]bool, len(orbs))
+		}
+		if len(orbs) != len(initiallyBound) {
+			log.Fatalf("'%s' holds %d orbs, the first snapshot %d\n", fileName, len(orbs), len(initiallyBound))
+		}
+
+		elements := nbody.OrbitalElements(orbs, g, normal)
+		var numBound, numEscaped int
+		var semiMajorAxes, eccentricities, inclinations []float64
+		for i := 1; i < len(elements); i++ {
+			e := elements[i]
+			if k == 0 {
+				initiallyBound[i] = e.Bound
+			}
+			if e.Bound {
+				numBound += 1
+				semiMajorAxes = append(semiMajorAxes, e.SemiMajorAxis)
+				eccentricities = append(eccentricities, e.Eccentricity)
+				inclinations = append(inclinations, e.Inclination)
+			} else if initiallyBound[i] {
+				numEscaped += 1
+			}
+
+			_, err = fmt.Fprintf(
+				elementsFile,
+				"%v, %v, %v, %v, %v, %v, %v, %v, %v\n",
+				k, time, i, e.SemiMajorAxis, e.Eccentricity, e.Inclination, e.Periapsis, e.Energy, e.Bound,
+			)
+			if err != nil {
+				log.Fatalln("Could not write to", elementsFileName, err)
+			}
+		}
+		fmt.Printf("%s: %v bound, %v unbound, %v escaped since the first snapshot\n", fileName, numBound, len(elements) - 1 - numBound, numEscaped)
+
+		// histograms of the bound orbs
+		for _, histogram := range []struct {
+			quantity string
+			values []float64
+			min, max float64
+		}{
+			{"semi_major_axis", semiMajorAxes, 0, *maxSemiMajorAxis},
+			{"eccentricity", eccentricities, 0, 1},
+			{"inclination", inclinations, 0, math.Pi},
+		} {
+			width := (histogram.max - histogram.min) / float64(*numBins)
+			for bin, count := range nbody.Histogram(histogram.values, histogram.min, histogram.max, *numBins) {
+				low := histogram.min + float64(bin) * width
+				_, err = fmt.Fprintf(histogramFile, "%v, %v, %s, %v, %v, %v\n", k, time, histogram.quantity, low, low + width, count)
+				if err != nil {
+					log.Fatalln("Could not write to", histogramFileName, err)
+				}
+			}
+		}
+	}
+}
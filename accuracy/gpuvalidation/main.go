@@ -0,0 +1,453 @@
+
+package main
+
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/go-gl/gl/v4.5-core/gl"
+	"github.com/go-gl/glfw/v3.2/glfw"
+	mgl "github.com/go-gl/mathgl/mgl32"
+
+	"github.com/ocean-of-serenity/gravsim/headless"
+	"github.com/ocean-of-serenity/gravsim/nbody"
+)
+
+
+type Location struct {
+	location mgl.Vec3
+	mass float32
+}
+
+type Velocity struct {
+	velocity mgl.Vec3
+	padding float32
+}
+
+// layout of the interleaved variants
+type Orb struct {
+	location Location
+	velocity Velocity
+}
+
+
+// storage layouts of the gravity compute shaders of the performance programs
+const (
+	separateLayout = iota		// locations with the mass in w at bindings 0 and 1, velocities at 2
+	massLayout		// locations at bindings 0 and 1, masses at 2, velocities at 3
+	interleavedLayout		// location and velocity of an orb next to each other at bindings 0 and 1
+)
+
+
+var variantDirectory = flag.String("variant", "../../performance/euler_shared_prefetch", "directory of the performance program whose gravity compute shader to validate")
+var numOrbs = flag.Int("orbs", 4096, "number of orbs of the disk")
+var numSteps = flag.Int("steps", 100, "number of steps to integrate")
+var seed = flag.Int64("seed", 1, "seed of the initial conditions")
+var localWorkGroupSize = flag.Int("workgroup", 128, "local work group size")
+var maxPositionTolerance = flag.Float64("max-position", 1.0, "largest tolerated position difference of a single orb, in solar radii")
+var rmsPositionTolerance = flag.Float64("rms-position", 0.1, "largest tolerated RMS position difference, in solar radii")
+var maxVelocityTolerance = flag.Float64("max-velocity", 1e-2, "largest tolerated velocity difference of a single orb, in solar radii per day")
+var rmsVelocityTolerance = flag.Float64("rms-velocity", 1e-3, "largest tolerated RMS velocity difference, in solar radii per day")
+var headlessMode = flag.Bool("headless", false, "run on a surfaceless EGL context instead of a hidden window")
+
+
+// a variant as far as the validation is concerned, its constants are read from the defines of its shader
+type Variant struct {
+	name string
+	layout int
+	integrator nbody.Integrator
+	G, deltaT, soften float64
+	softened bool
+	gravitySource, startupSource string
+}
+
+
+// runs the gravity compute shader of a performance program and the float64 CPU reference on the same initial
+// conditions, compares the final states and exits with status 1 if they differ by more than the tolerances
+func main() {
+	flag.Parse()
+	profilingFileName := fmt.Sprintf("accuracy-gpuvalidation-%s.csv", time.Now().Format("2006_01_02_15_04_05"))
+
+	variant, err := loadVariant(*variantDirectory)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+
+	// initialize GLFW and OpenGL with a hidden window, or only OpenGL on a surfaceless context in headless mode
+	if *headlessMode {
+		context, err := headless.NewContext(4, 5)
+		if err != nil {
+			log.Fatalln("Failed to create headless context", err)
+		}
+		defer context.Destroy()
+	} else {
+		if err := glfw.Init(); err != nil {
+			log.Fatalln("Failed to initialize glfw:", err)
+		}
+		defer glfw.Terminate()
+
+		glfw.WindowHint(glfw.ContextVersionMajor, 4)
+		glfw.WindowHint(glfw.ContextVersionMinor, 5)
+		glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
+		glfw.WindowHint(glfw.Visible, glfw.False)
+
+		window, err := glfw.CreateWindow(64, 64, "Gravity Simulation - GPU Validation", nil, nil)
+		if err != nil {
+			log.Fatalln("Failed to create window", err)
+		}
+		defer window.Destroy()
+
+		window.MakeContextCurrent()
+	}
+
+	if err := gl.Init(); err != nil {
+		log.Fatalln("Failed to initialize glow", err)
+	}
+
+
+	// both sides start from the same single precision initial conditions
+	initial := nbody.NewDisk(*numOrbs, 0, variant.G, rand.New(rand.NewSource(*seed)))
+	for i := range initial {
+		b := &initial[i]
+		b.Position, b.Velocity, b.Mass = roundVec3(b.Position), roundVec3(b.Velocity), float64(float32(b.Mass))
+	}
+
+	fmt.Printf("%s: %v orbs, %v steps\n", variant.name, *numOrbs, *numSteps)
+	gpu := runGPU(variant, initial)
+
+	system := &nbody.System{
+		Bodies: append([]nbody.Body(nil), initial...),
+		Law: nbody.Newtonian{G: variant.G},
+		Kernel: nbody.NoSoftening,
+	}
+	if variant.softened {
+		system.Kernel = nbody.PlummerSoftening
+		system.Softening = variant.soften
+	}
+	for i := 0; i < *numSteps; i++ {
+		variant.integrator.Step(system, variant.deltaT)
+	}
+
+	// verlet variants keep no velocities, both sides estimate them like the verlet profiling compute shaders
+	if variant.startupSource != "" {
+		accelerations := (&nbody.System{Bodies: gpu, Law: system.Law, Kernel: system.Kernel, Softening: system.Softening}).Accelerations()
+		for i := range gpu {
+			gpu[i].Velocity = gpu[i].Velocity.Add(accelerations[i].Mul(0.5 * variant.deltaT))
+		}
+	}
+
+
+	// write per orb differences to filesystem and check the tolerances
+	comparison := nbody.Compare(gpu, system.Bodies)
+	file, err := os.Create(profilingFileName)
+	if err != nil {
+		log.Fatalln("Could not create", profilingFileName, err)
+	}
+	defer file.Close()
+	fmt.Fprintln(file, "orb, position_difference, velocity_difference")
+	for i := range gpu {
+		_, err = fmt.Fprintf(file, "%v, %v, %v\n", i, comparison.Position.Values[i], comparison.Velocity.Values[i])
+		if err != nil {
+			log.Fatalln("Could not write to", profilingFileName, err)
+		}
+	}
+
+	failed := false
+	for _, check := range []struct {
+		name string
+		deviations nbody.Deviations
+		maxTolerance, rmsTolerance float64
+	}{
+		{"position", comparison.Position, *maxPositionTolerance, *rmsPositionTolerance},
+		{"velocity", comparison.Velocity, *maxVelocityTolerance, *rmsVelocityTolerance},
+	} {
+		d := check.deviations
+		fmt.Printf(
+			"%s: max %.4e, RMS %.4e, p50 %.4e, p90 %.4e, p99 %.4e\n",
+			check.name, d.Max, d.RMS, d.P50, d.P90, d.P99,
+		)
+		if d.Max > check.maxTolerance {
+			fmt.Printf("FAIL: max %s difference %.4e exceeds %.4e\n", check.name, d.Max, check.maxTolerance)
+			failed = true
+		}
+		if d.RMS > check.rmsTolerance {
+			fmt.Printf("FAIL: RMS %s difference %.4e exceeds %.4e\n", check.name, d.RMS, check.rmsTolerance)
+			failed = true
+		}
+	}
+
+	file.Close()
+	if failed {
+		os.Exit(1)
+	}
+	fmt.Println("PASS")
+}
+
+
+func init() {
+	// GLFW event handling must run on the main OS thread
+	runtime.LockOSThread()
+}
+
+
+var definePattern = regexp.MustCompile(`(?m)^#define (\w+) (\S+)`)
+
+
+func loadVariant(directory string) (Variant, error) {
+	v := Variant{name: filepath.Base(filepath.Clean(directory))}
+
+	source, err := ioutil.ReadFile(filepath.Join(directory, "gravity_compute_shader.glsl"))
+	if err != nil {
+		return v, fmt.Errorf("Could not read '%s': %s", directory, err)
+	}
+	v.gravitySource = string(source)
+
+	startupFileName := filepath.Join(directory, "gravity_startup_compute_shader.glsl")
+	if _, err := os.Stat(startupFileName); err == nil {
+		source, err := ioutil.ReadFile(startupFileName)
+		if err != nil {
+			return v, fmt.Errorf("Could not read '%s': %s", startupFileName, err)
+		}
+		v.startupSource = string(source)
+	}
+
+	switch {
+	case strings.Contains(v.gravitySource, "buffer Orbs0"):
+		v.layout = interleavedLayout
+	case strings.Contains(v.gravitySource, "buffer Masses"):
+		v.layout = massLayout
+	default:
+		v.layout = separateLayout
+	}
+
+	v.integrator, err = nbody.NewIntegrator(strings.SplitN(v.name, "_", 2)[0])
+	if err != nil {
+		return v, err
+	}
+
+	defines := map[string]float64{}
+	for _, match := range definePattern.FindAllStringSubmatch(v.gravitySource, -1) {
+		if value, err := strconv.ParseFloat(match[2], 64); err == nil {
+			defines[match[1]] = value
+		}
+	}
+	v.G, v.deltaT, v.soften = defines["G"], defines["DELTA_T"], defines["SOFTEN"]
+	if v.G == 0 || v.deltaT == 0 {
+		return v, fmt.Errorf("'%s' does not define G and DELTA_T", directory)
+	}
+	v.softened = strings.Contains(v.gravitySource, "SOFTEN * SOFTEN")
+	return v, nil
+}
+
+
+func roundVec3(v nbody.Vec3) nbody.Vec3 {
+	return nbody.Vec3{float64(float32(v[0])), float64(float32(v[1])), float64(float32(v[2]))}
+}
+
+
+func toVec3(v mgl.Vec3) nbody.Vec3 {
+	return nbody.Vec3{float64(v[0]), float64(v[1]), float64(v[2])}
+}
+
+
+func toLocation(b nbody.Body) Location {
+	return Location{mgl.Vec3{float32(b.Position[0]), float32(b.Position[1]), float32(b.Position[2])}, float32(b.Mass)}
+}
+
+
+func toVelocity(b nbody.Body) Velocity {
+	return Velocity{velocity: mgl.Vec3{float32(b.Velocity[0]), float32(b.Velocity[1]), float32(b.Velocity[2])}}
+}
+
+
+func newStorageBuffer(size int, data unsafe.Pointer) uint32 {
+	var buffer uint32
+	gl.CreateBuffers(1, &buffer)
+	gl.NamedBufferStorage(buffer, size, data, 0)
+	return buffer
+}
+
+
+// runGPU integrates initial numSteps steps with the shaders of variant and returns the final state, verlet variants
+// return the velocities estimated from their last two positions
+func runGPU(variant Variant, initial []nbody.Body) []nbody.Body {
+	numSpheres := len(initial)
+	globalWorkGroupSize := uint32(numSpheres) / uint32(*localWorkGroupSize)
+	if uint32(numSpheres) % uint32(*localWorkGroupSize) != 0 {
+		globalWorkGroupSize += 1
+	}
+
+	gravityProgram, err := newGravityProgram(variant.name, variant.gravitySource, uint32(*localWorkGroupSize), uint32(numSpheres), globalWorkGroupSize)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer gl.DeleteProgram(gravityProgram)
+
+	locations := make([]Location, numSpheres)
+	velocities := make([]Velocity, numSpheres)
+	masses := make([]float32, numSpheres)
+	orbs := make([]Orb, numSpheres)
+	for i, b := range initial {
+		locations[i], velocities[i], masses[i] = toLocation(b), toVelocity(b), float32(b.Mass)
+		orbs[i] = Orb{locations[i], velocities[i]}
+	}
+
+	// buffer0 holds the current state, buffer1 receives the next one; the verlet startup shader goes the other way
+	var buffer0, buffer1, velocityBuffer, massBuffer uint32
+	switch variant.layout {
+	case interleavedLayout:
+		buffer0 = newStorageBuffer(numSpheres * 4 * 4 * 2, unsafe.Pointer(&orbs[0]))
+		buffer1 = newStorageBuffer(numSpheres * 4 * 4 * 2, unsafe.Pointer(&orbs[0]))
+	case massLayout:
+		buffer0 = newStorageBuffer(numSpheres * 4 * 4, unsafe.Pointer(&locations[0]))
+		buffer1 = newStorageBuffer(numSpheres * 4 * 4, nil)
+		massBuffer = newStorageBuffer(numSpheres * 4, unsafe.Pointer(&masses[0]))
+		velocityBuffer = newStorageBuffer(numSpheres * 4 * 4, unsafe.Pointer(&velocities[0]))
+		gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 2, massBuffer)
+		gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 3, velocityBuffer)
+	default:
+		buffer0 = newStorageBuffer(numSpheres * 4 * 4, unsafe.Pointer(&locations[0]))
+		buffer1 = newStorageBuffer(numSpheres * 4 * 4, nil)
+		velocityBuffer = newStorageBuffer(numSpheres * 4 * 4, unsafe.Pointer(&velocities[0]))
+		gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 2, velocityBuffer)
+	}
+	defer gl.DeleteBuffers(4, &[]uint32{buffer0, buffer1, velocityBuffer, massBuffer}[0])
+
+	step := 0
+	if variant.startupSource != "" {
+		startupProgram, err := newGravityProgram(variant.name + " startup", variant.startupSource, uint32(*localWorkGroupSize), uint32(numSpheres), globalWorkGroupSize)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 0, buffer1)
+		gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 1, buffer0)
+		gl.UseProgram(startupProgram)
+		gl.DispatchCompute(globalWorkGroupSize, 1, 1)
+		gl.UseProgram(0)
+		gl.MemoryBarrier(gl.SHADER_STORAGE_BARRIER_BIT)
+		gl.DeleteProgram(startupProgram)
+		buffer0, buffer1 = buffer1, buffer0
+		step += 1
+	}
+
+	for ; step < *numSteps; step++ {
+		gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 0, buffer0)
+		gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 1, buffer1)
+		gl.UseProgram(gravityProgram)
+		gl.DispatchCompute(globalWorkGroupSize, 1, 1)
+		gl.UseProgram(0)
+		gl.MemoryBarrier(gl.SHADER_STORAGE_BARRIER_BIT)
+		buffer0, buffer1 = buffer1, buffer0
+	}
+	gl.MemoryBarrier(gl.BUFFER_UPDATE_BARRIER_BIT)
+	gl.Finish()
+
+
+	// read back the final state
+	final := make([]nbody.Body, numSpheres)
+	switch variant.layout {
+	case interleavedLayout:
+		gl.GetNamedBufferSubData(buffer0, 0, numSpheres * 4 * 4 * 2, unsafe.Pointer(&orbs[0]))
+		for i := range orbs {
+			locations[i], velocities[i] = orbs[i].location, orbs[i].velocity
+		}
+	default:
+		gl.GetNamedBufferSubData(buffer0, 0, numSpheres * 4 * 4, unsafe.Pointer(&locations[0]))
+		if variant.startupSource == "" {
+			gl.GetNamedBufferSubData(velocityBuffer, 0, numSpheres * 4 * 4, unsafe.Pointer(&velocities[0]))
+		}
+	}
+	for i := range final {
+		final[i].Position = toVec3(locations[i].location)
+		final[i].Velocity = toVec3(velocities[i].velocity)
+		final[i].Mass = float64(masses[i])
+	}
+
+	// the other buffer still holds the previous positions of the verlet variants, the acceleration half kick is left
+	// to the caller
+	if variant.startupSource != "" {
+		previous := make([]Location, numSpheres)
+		gl.GetNamedBufferSubData(buffer1, 0, numSpheres * 4 * 4, unsafe.Pointer(&previous[0]))
+		for i := range final {
+			final[i].Velocity = final[i].Position.Sub(toVec3(previous[i].location)).Mul(1 / variant.deltaT)
+		}
+	}
+	return final
+}
+
+
+// newGravityProgram fills in the templated defines of source, only as many as the shader has
+func newGravityProgram(name, source string, localWorkGroupSize, numSpheres, numTiles uint32) (uint32, error) {
+	parameters := []interface{}{localWorkGroupSize, numSpheres, numTiles}
+	source = fmt.Sprintf(source, parameters[:strings.Count(source, "%v")]...) + "\x00"
+
+	shader := gl.CreateShader(gl.COMPUTE_SHADER)
+	if shader == 0 {
+		return 0, fmt.Errorf("Could not create name for shader '%s'!", name)
+	}
+	defer gl.DeleteShader(shader)
+
+	cSources, free := gl.Strs(source)
+	gl.ShaderSource(shader, 1, cSources, nil)
+	free()
+
+	gl.CompileShader(shader)
+
+	var compileStatus int32
+	gl.GetShaderiv(shader, gl.COMPILE_STATUS, &compileStatus)
+	if compileStatus == gl.FALSE {
+		var infoLogLength int32
+		gl.GetShaderiv(shader, gl.INFO_LOG_LENGTH, &infoLogLength)
+
+		infoLog := strings.Repeat("\x00", int(infoLogLength + 1))
+		gl.GetShaderInfoLog(shader, infoLogLength, nil, gl.Str(infoLog))
+
+		return 0, fmt.Errorf(
+			"Failed to compile '%s'!\n#>> Source:\n%s\n#>> InfoLog:\n%s",
+			name,
+			source,
+			infoLog,
+		)
+	}
+
+	program := gl.CreateProgram()
+	if program == 0 {
+		return 0, fmt.Errorf("Could not create name for gravity program!")
+	}
+
+	gl.AttachShader(program, shader)
+	gl.LinkProgram(program)
+	gl.DetachShader(program, shader)
+
+	var linkStatus int32
+	gl.GetProgramiv(program, gl.LINK_STATUS, &linkStatus)
+	if linkStatus == gl.FALSE {
+		var infoLogLength int32
+		gl.GetProgramiv(program, gl.INFO_LOG_LENGTH, &infoLogLength)
+
+		infoLog := strings.Repeat("\x00", int(infoLogLength + 1))
+		gl.GetProgramInfoLog(program, infoLogLength, nil, gl.Str(infoLog))
+
+		gl.DeleteProgram(program)
+
+		return 0, fmt.Errorf(
+			"Failed to link gravity program!\n#>> InfoLog:\n%s",
+			infoLog,
+		)
+	}
+
+	return program, nil
+}
@@ -0,0 +1,82 @@
+
+package main
+
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ocean-of-serenity/gravsim/nbody"
+)
+
+
+const (
+	G = 1.142602313e-4		// Lunar Masses, Solar Radii and days
+)
+
+
+var linkingLength = flag.Float64("linking", 200, "linking length, in solar radii")
+var minMembers = flag.Int("min", 10, "smallest number of members of a group")
+var unbind = flag.Bool("unbind", false, "remove the members that are not bound to their group")
+var softening = flag.Float64("softening", 1, "Plummer softening length of the group potential when unbinding, in solar radii")
+
+
+// finds the friends-of-friends groups of the orbs of every snapshot given and writes a catalogue of them next to
+// each snapshot, named after it with the suffix -groups
+//
+// usage: groups [flags] snapshot.csv...
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatalln("No snapshots given")
+	}
+	if *linkingLength <= 0 {
+		log.Fatalln("Invalid linking length", *linkingLength)
+	}
+	if *minMembers < 1 {
+		log.Fatalln("Invalid smallest number of members", *minMembers)
+	}
+
+	for _, fileName := range flag.Args() {
+		orbs, metadata, err := nbody.ReadSnapshot(fileName)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		g := G
+		if value, err := strconv.ParseFloat(metadata["G"], 64); err == nil {
+			g = value
+		}
+
+		groups := nbody.FindGroups(orbs, *linkingLength, *minMembers)
+		if *unbind {
+			groups = nbody.UnbindGroups(orbs, groups, g, *softening, *minMembers)
+		}
+		fmt.Printf("%s: %v groups\n", fileName, len(groups))
+
+		catalogueFileName := strings.TrimSuffix(fileName, ".csv") + "-groups.csv"
+		catalogueFile, err := os.Create(catalogueFileName)
+		if err != nil {
+			log.Fatalln("Could not create", catalogueFileName, err)
+		}
+		fmt.Fprintf(catalogueFile, "# linking_length: %v\n# unbound: %v\n", *linkingLength, *unbind)
+		fmt.Fprintln(catalogueFile, "group, members, mass, x, y, z, vx, vy, vz, radius")
+		for k, group := range groups {
+			_, err = fmt.Fprintf(
+				catalogueFile,
+				"%v, %v, %v, %v, %v, %v, %v, %v, %v, %v\n",
+				k, len(group.Members), group.Mass,
+				group.Centre[0], group.Centre[1], group.Centre[2],
+				group.Velocity[0], group.Velocity[1], group.Velocity[2],
+				group.Radius,
+			)
+			if err != nil {
+				log.Fatalln("Could not write to", catalogueFileName, err)
+			}
+		}
+		catalogueFile.Close()
+	}
+}
@@ -4,8 +4,13 @@
 
 uniform mat4 projection;
 uniform mat4 view;
+uniform float interpolation = 1;		// fraction of the way from the last to the current locations
 
 
+layout(std430, binding=0) readonly buffer LastLocations {
+	vec4 last_locations[];
+};
+
 layout(std430, binding=1) readonly buffer Locations {
 	vec4 locations[];
 };
@@ -30,7 +35,7 @@ void main() {
     out_.instance = in_[0].instance;
 
 	mat4 model = in_[0].model;
-	model[3].xyz = locations[out_.instance].xyz;
+	model[3].xyz = mix(last_locations[out_.instance].xyz, locations[out_.instance].xyz, interpolation);
 
     vec3 p0 = gl_TessCoord.x * gl_in[0].gl_Position.xyz;
     vec3 p1 = gl_TessCoord.y * gl_in[1].gl_Position.xyz;
@@ -0,0 +1,76 @@
+
+package main
+
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/ocean-of-serenity/gravsim/nbody"
+)
+
+
+const (
+	G = 1.142602313e-4		// Lunar Masses, Solar Radii and days
+	deltaT = 1.0
+
+	softeningLength = 1.0
+)
+
+
+var integratorName = flag.String("integrator", "verlet", "integrator of both the system and its shadow")
+var numOrbs = flag.Int("orbs", 256, "number of orbs of the disk")
+var numFrames = flag.Int("frames", 10000, "number of steps of deltaT to integrate")
+var interval = flag.Int("interval", 10, "steps between two renormalisations of the shadow")
+var separation = flag.Float64("separation", 1e-6, "phase space distance of the shadow, in solar radii")
+var seed = flag.Int64("seed", 1, "seed of the initial conditions and the perturbation")
+
+
+// evolves the initial conditions of the accuracy programs together with a slightly perturbed shadow copy on the CPU
+// and reports the maximal Lyapunov exponent and the e-folding time. Both copies run on the float64 reference
+// integrators of package nbody, so this measures the chaos of the CPU reference only, not of the GPU simulation in
+// single precision.
+func main() {
+	flag.Parse()
+	profilingFileName := fmt.Sprintf("accuracy-lyapunov-%s.csv", time.Now().Format("2006_01_02_15_04_05"))
+
+	if _, err := nbody.NewIntegrator(*integratorName); err != nil {
+		log.Fatalln(err)
+	}
+	newIntegrator := func() nbody.Integrator {
+		integrator, _ := nbody.NewIntegrator(*integratorName)
+		return integrator
+	}
+
+	r := rand.New(rand.NewSource(*seed))
+	system := &nbody.System{
+		Bodies: nbody.NewDisk(*numOrbs, 0, G, r),
+		Law: nbody.Newtonian{G: G},
+		Kernel: nbody.PlummerSoftening,
+		Softening: softeningLength,
+	}
+
+	// the orbital period at the inner edge of the disk weighs velocities against positions
+	timeScale := 2 * math.Pi * math.Sqrt(1000.0 * 1000.0 * 1000.0 / (G * system.Bodies[0].Mass))
+
+	l := nbody.MeasureLyapunov(system, newIntegrator, deltaT, *numFrames, *interval, *separation, timeScale, r)
+	fmt.Printf("%s: maximal Lyapunov exponent %.6e per day, e-folding time %.6e days\n", *integratorName, l.Exponent, l.EFoldingTime)
+
+	file, err := os.Create(profilingFileName)
+	if err != nil {
+		log.Fatalln("Could not create", profilingFileName, err)
+	}
+	defer file.Close()
+	fmt.Fprintln(file, "time, lyapunov_exponent")
+	for i := range l.Times {
+		_, err = fmt.Fprintf(file, "%v, %v\n", l.Times[i], l.Exponents[i])
+		if err != nil {
+			log.Fatalln("Could not write to", profilingFileName, err)
+		}
+	}
+}
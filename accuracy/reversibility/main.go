@@ -0,0 +1,67 @@
+
+package main
+
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ocean-of-serenity/gravsim/nbody"
+)
+
+
+const (
+	G = 1.142602313e-4		// Lunar Masses, Solar Radii and days
+	deltaT = 1.0
+	numFrames = 1000
+
+	softeningLength = 1.0
+)
+
+
+var integratorNames = flag.String("integrators", "euler,heun,verlet", "comma separated integrators to measure")
+var numOrbs = flag.Int("orbs", 256, "number of orbs of the disk")
+var seed = flag.Int64("seed", 1, "seed of the initial conditions")
+
+
+// integrates the initial conditions of the accuracy programs numFrames frames forward and back again on the CPU and
+// reports how far every orb ends up from where it started, per integrator
+func main() {
+	flag.Parse()
+	profilingFileName := fmt.Sprintf("accuracy-reversibility-%s.csv", time.Now().Format("2006_01_02_15_04_05"))
+
+	system := &nbody.System{
+		Bodies: nbody.NewDisk(*numOrbs, 0, G, rand.New(rand.NewSource(*seed))),
+		Law: nbody.Newtonian{G: G},
+		Kernel: nbody.PlummerSoftening,
+		Softening: softeningLength,
+	}
+
+	file, err := os.Create(profilingFileName)
+	if err != nil {
+		log.Fatalln("Could not create", profilingFileName, err)
+	}
+	defer file.Close()
+	fmt.Fprintln(file, "integrator, orb, return_error")
+
+	for _, name := range strings.Split(*integratorNames, ",") {
+		integrator, err := nbody.NewIntegrator(strings.TrimSpace(name))
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		r := nbody.MeasureReversibility(system, integrator, deltaT, numFrames)
+		fmt.Printf("%s: max %.6e, RMS %.6e, median %.6e\n", r.Integrator, r.Max, r.RMS, r.Median)
+		for i, e := range r.Errors {
+			_, err = fmt.Fprintf(file, "%s, %v, %v\n", r.Integrator, i, e)
+			if err != nil {
+				log.Fatalln("Could not write to", profilingFileName, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+
+package main
+
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/ocean-of-serenity/gravsim/nbody"
+)
+
+
+const (
+	G = 1.142602313e-4		// Lunar Masses, Solar Radii and days
+)
+
+
+var outputPrefix = flag.String("output", "accuracy-structure", "prefix of the CSV files written")
+var numBins = flag.Int("bins", 24, "number of radial bins")
+var innerRadius = flag.Float64("inner", 500, "inner edge of the radial bins, in solar radii")
+var outerRadius = flag.Float64("outer", 30000, "outer edge of the radial bins, in solar radii")
+
+
+var lagrangianFractions = []float64{0.1, 0.5, 0.9}
+
+
+// computes the radial structure of the orbs of a series of snapshots around the central orb at index 0: the 10, 50
+// and 90% Lagrangian radii, and spherical and cylindrical profiles of density, rotation and velocity dispersion. The
+// central orb itself is left out of the profiles, its mass only counts for the circular velocity, which is only given
+// for the spherical binning. Without massive orbs besides the central one the density is a number density.
+//
+// usage: structure [flags] snapshot.csv...
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatalln("No snapshots given")
+	}
+
+	lagrangianFileName := *outputPrefix + "-lagrangian.csv"
+	lagrangianFile, err := os.Create(lagrangianFileName)
+	if err != nil {
+		log.Fatalln("Could not create", lagrangianFileName, err)
+	}
+	defer lagrangianFile.Close()
+	fmt.Fprintln(lagrangianFile, "snapshot, time, r10, r50, r90")
+
+	profileFileName := *outputPrefix + "-profiles.csv"
+	profileFile, err := os.Create(profileFileName)
+	if err != nil {
+		log.Fatalln("Could not create", profileFileName, err)
+	}
+	defer profileFile.Close()
+	fmt.Fprintln(profileFile, "snapshot, time, binning, r_inner, r_outer, count, mass, density, rotation_velocity, circular_velocity, sigma_r, sigma_phi, sigma_z")
+
+	edges := nbody.LogEdges(*innerRadius, *outerRadius, *numBins)
+	for k, fileName := range flag.Args() {
+		orbs, metadata, err := nbody.ReadSnapshot(fileName)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		g := G
+		if value, err := strconv.ParseFloat(metadata["G"], 64); err == nil {
+			g = value
+		}
+		time := metadata["time"]
+		if time == "" {
+			time = fmt.Sprint(k)
+		}
+
+		// the axis is the normal of the disk, oriented so that it rotates in the positive phi direction
+		axis := nbody.OrbitalAngularMomentum(orbs)
+		if length := axis.Len(); length > 0 {
+			axis = axis.Mul(1 / length)
+		} else {
+			axis = nbody.Vec3{0, 1, 0}
+		}
+		frame := nbody.Frame{Centre: orbs[0].Position, Velocity: orbs[0].Velocity, Axis: axis}
+
+		radii := nbody.LagrangianRadii(orbs[1:], frame.Centre, lagrangianFractions)
+		_, err = fmt.Fprintf(lagrangianFile, "%v, %v, %v, %v, %v\n", k, time, radii[0], radii[1], radii[2])
+		if err != nil {
+			log.Fatalln("Could not write to", lagrangianFileName, err)
+		}
+		fmt.Printf("%s: Lagrangian radii %.4g, %.4g, %.4g\n", fileName, radii[0], radii[1], radii[2])
+
+		for _, binning := range []string{"spherical", "cylindrical"} {
+			for _, bin := range nbody.RadialProfile(orbs[1:], frame, edges, binning == "cylindrical") {
+				// the enclosed mass of a cylinder says nothing about the circular velocity
+				circularVelocity := ""
+				if binning == "spherical" {
+					circularVelocity = fmt.Sprint(math.Sqrt(g * (orbs[0].Mass + bin.EnclosedMass) / bin.Outer))
+				}
+				_, err = fmt.Fprintf(
+					profileFile,
+					"%v, %v, %s, %v, %v, %v, %v, %v, %v, %s, %v, %v, %v\n",
+					k, time, binning, bin.Inner, bin.Outer, bin.Count, bin.Mass, bin.Density,
+					bin.RotationVelocity, circularVelocity, bin.SigmaR, bin.SigmaPhi, bin.SigmaZ,
+				)
+				if err != nil {
+					log.Fatalln("Could not write to", profileFileName, err)
+				}
+			}
+		}
+	}
+}
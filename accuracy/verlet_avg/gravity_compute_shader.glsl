@@ -4,14 +4,36 @@
 
 //#define G 1.887130407e-7
 #define G 1.142602313e-4
-#define DELTA_T 1
-#define SOFTEN 1
 
 
 #define LOCAL_WORKGROUP_SIZE %v
 #define NUM_SPHERES %v
 #define NUM_TILES %v
 
+// DELTA_T, SOFTEN and the other simulation parameters, the force law functions source_coupling, pair_field,
+// pair_potential, target_acceleration and target_potential_energy and post_newtonian_acceleration are defined by the
+// host program, see computeShaderPreamble in main.go
+
+// only the first NUM_MASSIVE_ORBS orbs source gravity, the remaining ones are massless tracers
+#if NUM_MASSIVE_ORBS > 0 && NUM_MASSIVE_ORBS < NUM_SPHERES
+#define NUM_SOURCES NUM_MASSIVE_ORBS
+#else
+#define NUM_SOURCES NUM_SPHERES
+#endif
+#define NUM_SOURCE_TILES ((NUM_SOURCES + LOCAL_WORKGROUP_SIZE - 1) / LOCAL_WORKGROUP_SIZE)
+
+#define PLUMMER_SOFTENING 0
+#define SPLINE_SOFTENING 1
+#define NO_SOFTENING 2
+
+#define POINT_MASS_POTENTIAL 0
+#define PLUMMER_POTENTIAL 1
+#define HERNQUIST_POTENTIAL 2
+#define NFW_POTENTIAL 3
+#define MIYAMOTO_NAGAI_POTENTIAL 4
+#define LOGARITHMIC_POTENTIAL 5
+#define BAR_POTENTIAL 6
+
 
 layout(local_size_x=LOCAL_WORKGROUP_SIZE) in;
 
@@ -24,8 +46,166 @@ layout(std430, binding=1) buffer Locations1 {
 	vec4 locations1[];
 };
 
+#if PER_PARTICLE_SOFTENING
+layout(std430, binding=4) readonly buffer Softenings {
+	float softenings[];
+};
+#endif
+
+#if CHARGED
+layout(std430, binding=6) readonly buffer Charges {
+	float charges[];
+};
+#define CHARGE(index) charges[index]
+#else
+#define CHARGE(index) 0
+#endif
+
+#if NUM_EXTERNAL_POTENTIALS > 0
+struct ExternalPotential {
+	int kind;
+	float parameters[3];
+};
+
+layout(std430, binding=5) readonly buffer ExternalPotentials {
+	ExternalPotential external_potentials[];
+};
+#endif
+
+
+uniform float simulation_time;
+
+#if COMOVING
+// drift and kick factors of the current step and the drift factor of the previous one, see nbody.Cosmology
+uniform float drift_factor;
+uniform float kick_factor;
+uniform float last_drift_factor;
+#define DRIFT drift_factor
+#define KICK kick_factor
+#define LAST_DRIFT last_drift_factor
+#else
+#define DRIFT DELTA_T
+#define KICK DELTA_T
+#define LAST_DRIFT DELTA_T
+#endif
+
 
 shared vec4 shared_locations[LOCAL_WORKGROUP_SIZE];
+#if PER_PARTICLE_SOFTENING
+shared float shared_softenings[LOCAL_WORKGROUP_SIZE];
+#endif
+
+
+// Plummer softening spreads each mass over a Plummer sphere of scale length eps, the cubic spline kernel
+// (Monaghan & Lattanzio 1985) has compact support of radius 2.8 * eps and is exactly Newtonian beyond it;
+// without softening coincident positions, i.e. the self interaction, are treated as force free
+float softened_inverse_cube(const float r2, const float eps) {
+#if SOFTENING_KERNEL == SPLINE_SOFTENING
+	const float h = 2.8 * eps;
+	const float r = sqrt(r2);
+	if( r >= h ) {
+		return 1 / (r2 * r);
+	}
+	const float u = r / h;
+	const float h_inverse_cube = 1 / (h * h * h);
+	if( u < 0.5 ) {
+		return h_inverse_cube * (10.666666667 + u * u * (32.0 * u - 38.4));
+	}
+	return h_inverse_cube * (21.333333333 - 48.0 * u + 38.4 * u * u - 10.666666667 * u * u * u - 0.066666667 / (u * u * u));
+#elif SOFTENING_KERNEL == NO_SOFTENING
+	return r2 > 0 ? 1 / (r2 * sqrt(r2)) : 0;
+#else
+	const float brackets = r2 + eps * eps;
+	return 1 / sqrt(brackets * brackets * brackets);
+#endif
+}
+
+// softened counterpart of 1/r, so that the potential energy matches the force law above
+float softened_inverse_distance(const float r2, const float eps) {
+#if SOFTENING_KERNEL == SPLINE_SOFTENING
+	const float h = 2.8 * eps;
+	const float r = sqrt(r2);
+	if( r >= h ) {
+		return 1 / r;
+	}
+	const float u = r / h;
+	if( u < 0.5 ) {
+		return (2.8 - u * u * (5.333333333 + u * u * (6.4 * u - 9.6))) / h;
+	}
+	return (3.2 - 0.066666667 / u - u * u * (10.666666667 + u * (-16.0 + u * (9.6 - 2.133333333 * u)))) / h;
+#elif SOFTENING_KERNEL == NO_SOFTENING
+	return r2 > 0 ? 1 / sqrt(r2) : 0;
+#else
+	return 1 / sqrt(r2 + eps * eps);
+#endif
+}
+
+#if NUM_EXTERNAL_POTENTIALS > 0
+// acceleration caused by the static external potentials; all of them are centered on the origin and, where they
+// are not spherically symmetric, have the xz-plane, i.e. the plane of the disk, as their plane of symmetry
+vec3 external_acceleration(const vec3 x, const float t) {
+	vec3 sum = vec3(0, 0, 0);
+
+	const float r2 = dot(x, x);
+	if( r2 == 0 ) {
+		return sum;
+	}
+	const float r = sqrt(r2);
+	const float cylindrical_r2 = x.x * x.x + x.z * x.z;
+
+	for( int p = 0; p < NUM_EXTERNAL_POTENTIALS; p++ ) {
+		const float p0 = external_potentials[p].parameters[0];
+		const float p1 = external_potentials[p].parameters[1];
+		const float p2 = external_potentials[p].parameters[2];
+		switch( external_potentials[p].kind ) {
+		case POINT_MASS_POTENTIAL: {
+			sum -= (G * p0 / (r2 * r)) * x;
+			break;
+		}
+		case PLUMMER_POTENTIAL: {
+			const float brackets = r2 + p1 * p1;
+			sum -= (G * p0 / sqrt(brackets * brackets * brackets)) * x;
+			break;
+		}
+		case HERNQUIST_POTENTIAL: {
+			sum -= (G * p0 / (r * (r + p1) * (r + p1))) * x;
+			break;
+		}
+		case NFW_POTENTIAL: {
+			sum -= (G * p0 * (log(1 + r / p1) - r / (r + p1)) / (r2 * r)) * x;
+			break;
+		}
+		case MIYAMOTO_NAGAI_POTENTIAL: {
+			const float s = sqrt(x.y * x.y + p2 * p2);
+			const float brackets = cylindrical_r2 + (p1 + s) * (p1 + s);
+			sum -= (G * p0 / sqrt(brackets * brackets * brackets)) * vec3(x.x, x.y * (p1 + s) / s, x.z);
+			break;
+		}
+		case LOGARITHMIC_POTENTIAL: {
+			const float brackets = p1 * p1 + cylindrical_r2 + x.y * x.y / (p2 * p2);
+			sum -= (p0 * p0 / brackets) * vec3(x.x, x.y / (p2 * p2), x.z);
+			break;
+		}
+		case BAR_POTENTIAL: {
+			if( cylindrical_r2 == 0 ) {
+				break;
+			}
+			const float cylindrical_r = sqrt(cylindrical_r2);
+			const float angle = 2 * (atan(x.z, x.x) - p2 * t);
+			const float u = cylindrical_r / p1;
+			const float radial_profile = u < 1 ? u * u * u - 2 : -1 / (u * u * u);
+			const float radial_profile_derivative = u < 1 ? 3 * u * u / p1 : 3 / (p1 * u * u * u * u);
+			const float radial = -p0 * cos(angle) * radial_profile_derivative;
+			const float azimuthal = 2 * p0 * sin(angle) * radial_profile / cylindrical_r;
+			sum += (1 / cylindrical_r) * (radial * vec3(x.x, 0, x.z) + azimuthal * vec3(-x.z, 0, x.x));
+			break;
+		}
+		}
+	}
+
+	return sum;
+}
+#endif
 
 
 void main() {
@@ -34,37 +214,74 @@ void main() {
 	}
 
 	vec4 location = locations0[gl_GlobalInvocationID.x];
+	const float charge = CHARGE(gl_GlobalInvocationID.x);
+#if PER_PARTICLE_SOFTENING
+	const float softening = softenings[gl_GlobalInvocationID.x];
+#endif
 
 	vec4 prefetch_location;
-	if( gl_LocalInvocationID.x < NUM_SPHERES ) {
+#if PER_PARTICLE_SOFTENING
+	float prefetch_softening;
+#endif
+	if( gl_LocalInvocationID.x < NUM_SOURCES ) {
 		prefetch_location = locations0[gl_LocalInvocationID.x];
+		prefetch_location.w = source_coupling(prefetch_location.w, CHARGE(gl_LocalInvocationID.x));
+#if PER_PARTICLE_SOFTENING
+		prefetch_softening = softenings[gl_LocalInvocationID.x];
+#endif
 	}
 
 	vec3 sum = vec3(0, 0, 0);
-	for( int tile = 0; tile < NUM_TILES; tile++ ) {
+	for( int tile = 0; tile < NUM_SOURCE_TILES; tile++ ) {
 		shared_locations[gl_LocalInvocationID.x] = prefetch_location;
+#if PER_PARTICLE_SOFTENING
+		shared_softenings[gl_LocalInvocationID.x] = prefetch_softening;
+#endif
 		memoryBarrierShared();
 		barrier();
 
 		const uint tile_fetch_index = (tile + 1) * LOCAL_WORKGROUP_SIZE + gl_LocalInvocationID.x;
-		if( tile_fetch_index < NUM_SPHERES ) {
+		if( tile_fetch_index < NUM_SOURCES ) {
 			prefetch_location = locations0[tile_fetch_index];
+			prefetch_location.w = source_coupling(prefetch_location.w, CHARGE(tile_fetch_index));
+#if PER_PARTICLE_SOFTENING
+			prefetch_softening = softenings[tile_fetch_index];
+#endif
 		}
 		barrier();
 
 		const uint tile_start_index = tile * LOCAL_WORKGROUP_SIZE;
-		for( int i = 0; tile_start_index + i < NUM_SPHERES && i < LOCAL_WORKGROUP_SIZE; i++ ) {
+		for( int i = 0; tile_start_index + i < NUM_SOURCES && i < LOCAL_WORKGROUP_SIZE; i++ ) {
 			const vec3 dv = shared_locations[i].xyz - location.xyz;
-			const float brackets = dot(dv, dv) + SOFTEN * SOFTEN;
-			const float divisor = sqrt(brackets * brackets * brackets);
-			sum += (shared_locations[i].w / divisor) * dv;
+#if PER_PARTICLE_SOFTENING
+			const float eps = max(softening, shared_softenings[i]);
+#else
+			const float eps = SOFTEN;
+#endif
+			const float r2 = dot(dv, dv);
+			sum += pair_field(dv, softened_inverse_cube(r2, eps), softened_inverse_distance(r2, eps), shared_locations[i].w);
 		}
 	}
-	const vec3 acceleration = sum * G;
+	vec3 acceleration = target_acceleration(sum, location.w, charge);
+#if NUM_EXTERNAL_POTENTIALS > 0
+	acceleration += external_acceleration(location.xyz, simulation_time);
+#endif
+#if COMOVING
+	// the orbs form a sphere of background density in empty space, whose missing outside pulls them outwards
+	acceleration += BACKGROUND_ACCELERATION * location.xyz;
+#endif
 
 	const vec4 last_location = locations1[gl_GlobalInvocationID.x];
+#if POST_NEWTONIAN
+	// velocities are estimated like in the profiling compute shader; the central orb is taken to be at rest, since
+	// its last location might already be overwritten by this dispatch
+	if( gl_GlobalInvocationID.x != 0 ) {
+		const vec3 velocity = (location.xyz - last_location.xyz) / LAST_DRIFT + KICK * 0.5 * acceleration;
+		acceleration += post_newtonian_acceleration(location.xyz - locations0[0].xyz, velocity, location.w, locations0[0].w);
+	}
+#endif
 
-	location.xyz += location.xyz - last_location.xyz + DELTA_T * DELTA_T * acceleration;
+	location.xyz += (DRIFT / LAST_DRIFT) * (location.xyz - last_location.xyz) + DRIFT * KICK * acceleration;
 
 	locations1[gl_GlobalInvocationID.x] = location;
 }
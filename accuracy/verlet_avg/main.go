@@ -3,6 +3,8 @@ package main
 
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -18,6 +20,10 @@ import (
 	"github.com/go-gl/gl/v4.5-core/gl"
 	"github.com/go-gl/glfw/v3.2/glfw"
 	mgl "github.com/go-gl/mathgl/mgl32"
+
+	"github.com/ocean-of-serenity/gravsim/headless"
+	"github.com/ocean-of-serenity/gravsim/nbody"
+	"github.com/ocean-of-serenity/gravsim/recording"
 )
 
 
@@ -43,11 +49,29 @@ type Velocity struct {
 	padding float32
 }
 
+type ExternalPotential struct {
+	kind int32
+	parameters [3]float32
+}
+
+// uniform locations of the comoving drift and kick factors of a compute program
+type StepFactorUniforms struct {
+	drift, kick, lastDrift int32
+}
+
+// layout of the results of the profiling compute shader, one per work group
 type ConservedQuantities struct {
 	angularMomentum mgl.Vec3
 	totalEnergy float32
 	totalForce mgl.Vec3
-	totalForceMagnitude float32
+	sumForceMagnitudes float32
+	linearMomentum mgl.Vec3
+	totalMass float32
+	massLocation mgl.Vec3
+	_ float32
+	kineticEnergy float32
+	potentialEnergy float32
+	_ [2]float32
 }
 
 
@@ -55,60 +79,188 @@ const (
 	initialWindowWidth = 1280
 	initialWindowHeight = 720
 
-	cameraRotationDistancePerFrame = ((2 * math.Pi) / 8) * (1 / 64.0)
+	cameraAngularAcceleration = math.Pi / 2		// radians per second squared while an orbit key is held
+	cameraFlyAcceleration = 40000.0		// solar radii per second squared while a free-fly key is held
+	cameraMouseSensitivity = 0.004		// radians per pixel of mouse drag
+	cameraZoomPerScroll = 1.5		// e-foldings of the distance per second and scroll step
+	cameraFlyPerScroll = 4000.0		// solar radii per second and scroll step in free-fly mode
+	cameraDamping = 0.02		// fraction of the camera motion left after one second
+	cameraFollowDamping = 0.001		// fraction of the way to a followed orb or bookmark left after one second
+	cameraMinDistance = 64.0
+	cameraMaxDistance = 44000.0
+
+	cameraBookmarksFileName = "camera-bookmarks.csv"
 
 	G = 1.142602313e-4		// Lunar Masses, Solar Radii and days
+	deltaT = 1.0
+
+	softeningKernel = plummerSoftening
+	softeningLength = 1.0
+	perParticleSoftening = false
+	softeningReferenceMass = 1.0		// orbs of this mass get a softening length of exactly softeningLength
+
+	numMassiveOrbs = 0		// orbs beyond the first numMassiveOrbs are massless tracers; 0 makes every orb massive
+
+	chargePerMass = 1.0		// magnitude of the charge of an orb per lunar mass, only used by charged force laws
+
+	comoving = false		// integrate a uniform sphere in comoving coordinates, see cosmology below
+	initialRedshift = 49.0
+	finalRedshift = 0.0
+	comovingRadius = 22000.0
+
+	speedOfLight = 37232.0		// in solar radii per day, lower it to exaggerate the post-Newtonian corrections
 
 	numFrames = 1000
 
-	numProfilingRuns = 100
+	encounterDistance = 50.0		// orbs closer than this are in a close encounter, in solar radii
+	binaryDistance = 500.0		// bound orbs closer than this are binary candidates, in solar radii
+	minBinaryTime = 100.0		// days that candidates have to stay bound to count as a binary
+
+	maxDistance = 1e6		// orbs farther than this from the origin count as runaways, in solar radii
+)
+
+
+// softening kernels, mirrored by the defines of the same name in the compute shaders
+const (
+	plummerSoftening = iota
+	splineSoftening
+	noSoftening
+)
+
+// external potential kinds, mirrored by the defines of the same name in the compute shaders
+const (
+	pointMassPotential = iota
+	plummerPotential
+	hernquistPotential
+	nfwPotential
+	miyamotoNagaiPotential
+	logarithmicPotential
+	barPotential
 )
 
 
 var gravityProgram, gravityStartupProgram, profilingProgram, axisProgram, sphereProgram uint32
 var axisVertexArray, sphereVertexArray, sphereInstanceColorBuffer, sphereInstanceModelBuffer uint32
-var gravityLocationBuffer0, gravityLocationBuffer1, gravityVelocityBuffer, profileResultsBuffer uint32
+var gravityLocationBuffer0, gravityLocationBuffer1, gravityVelocityBuffer, gravitySofteningBuffer, gravityChargeBuffer, gravityExternalPotentialBuffer, profileResultsBuffer uint32
+
+var axisProgramView, sphereProgramView, sphereProgramCameraLocation, axisProgramProjection, sphereProgramProjection int32
+var sphereProgramInterpolation int32
+var gravityProgramSimulationTime, profilingProgramSimulationTime int32
+var gravityProgramStepFactors, gravityStartupProgramStepFactors, profilingProgramStepFactors StepFactorUniforms
+
+// static potentials acting on all orbs in addition to their self-gravity, e.g. a dark matter halo and a bulge
+// around the disk: []ExternalPotential{newNFWPotential(1e12, 40000), newHernquistPotential(1e10, 2000)}
+var externalPotentials = []ExternalPotential{}
 
-var axisProgramView, sphereProgramView, sphereProgramCameraLocation int32
+// interaction between the orbs, e.g. nbody.Yukawa{G: G, Length: 20000}, nbody.MOND{G: G, A0: 1e-6} or
+// nbody.Coulomb{K: G}; the shaders get the GLSL implementation of the same law
+var forceLaw nbody.ForceLaw = nbody.Newtonian{G: G}
+
+// post-Newtonian corrections to the orbits around the central orb, FirstOrder for the 1PN periapsis precession and
+// RadiationReaction for the 2.5PN loss of orbital energy
+var postNewtonian = nbody.PostNewtonian{G: G, C: speedOfLight, FirstOrder: false, RadiationReaction: false}
+
+// background of comoving runs, H0 of 70 km/s/Mpc in 1/days, and the redshifts at which to write snapshots
+var cosmology = nbody.Cosmology{OmegaMatter: 0.3, OmegaLambda: 0.7, H0: 1.96e-13}
+var snapshotRedshifts = []float64{9, 3, 1, 0}
 
 var camera Camera = Camera{mgl.Vec3{8000.0, 12000.0, 16000.0}, mgl.Vec3{0, 0, 0}}
 
 var leftKeyPressed, rightKeyPressed, upKeyPressed, downKeyPressed bool
 var leftKeyOn, rightKeyOn, upKeyOn, downKeyOn bool
-var moveLeft, moveRight, moveUp, moveDown bool
 
 var scrolling bool
 var scrollDirection float32
 
-var profilingLog []ConservedQuantities
+var dragging bool
+var cursorX, cursorY float64
+var cursorDeltaX, cursorDeltaY float32
+
+// camera motion, the orbit around the watched point or the flight of the camera itself in free-fly mode
+var freeFly bool
+var cameraYawVelocity, cameraPitchVelocity, cameraZoomVelocity float32
+var cameraFlyVelocity mgl.Vec3
+
+// the camera follows the orb at followedOrb, -1 follows none, or the centre of mass, or flies to a recalled bookmark
+var followedOrb int = -1
+var numFollowableOrbs int		// orbs of the current run, [ and ] keep followedOrb below it
+var followCenterOfMass bool
+var centerOfMass mgl.Vec3		// as of the last profiling reduction
+var cameraGoal *Camera
+var cameraBookmarks = map[int]Camera{}
+
+var pauseKeyPressed, stepKeyPressed, fasterKeyPressed, slowerKeyPressed, reverseKeyPressed bool
+
+var profilingLog []nbody.Conserved
 var profilingFileName string
+var startTime = time.Now()
+var lastFrameTime float64
+
+// frames between two rows of the conserved quantities time series, 0 only logs start and end; frames between two
+// snapshots of all orbs for the analysis tools, 0 writes none; and frames between two readbacks of all orbs to detect
+// close encounters and binaries, 0 detects none
+var profilingInterval, snapshotInterval, encounterInterval int = 10, 0, 0
+
+// frames between two checks of all orbs for non-finite positions and velocities and for runaways, 0 checks none; a run
+// stops at the first failed check
+var checkInterval int = 0
+
+// relative energy and angular momentum errors at which a run stops as failed, checked every profilingInterval frames
+// outside of comoving runs, 0 checks none
+var maxEnergyError, maxAngularMomentumError float64 = 0, 0
+
+var numProfilingRuns = flag.Int("runs", 100, "number of repeated profiling runs")
+var headlessMode = flag.Bool("headless", false, "run on a surfaceless EGL context without a window and render nothing but recordings")
+var recordPath = flag.String("record", "", "directory for PNG files or .y4m file to record the frames of all runs into, empty records nothing")
+var recordInterval = flag.Int("record-every", 1, "record every Kth simulation step")
+var recordWidth = flag.Int("record-width", 1920, "width of the recorded frames in pixels")
+var recordHeight = flag.Int("record-height", 1080, "height of the recorded frames in pixels")
+var recordFramesPerSecond = flag.Int("record-fps", 30, "frame rate of .y4m recordings")
+var substeps = flag.Int("substeps", 1, "simulation steps per rendered frame")
+var daysPerSecond = flag.Float64("realtime", 0, "simulated days per second of real time in the window, 0 runs as fast as possible")
+var maxFramesPerSecond = flag.Float64("fps", 0, "upper limit of rendered frames per second, 0 renders as fast as possible")
+var followIndex = flag.Int("follow", 0, "orb that O follows, 0 is the central orb")
+var viewerMode = flag.Bool("viewer", false, "run until the window is closed, with hotkeys to pause, step, change the speed and reverse time")
 
 
 func main() {
 	// misc setup
+	flag.Parse()
 	profilingFileName = fmt.Sprintf("accuracy-verlet_avg-%s.csv", time.Now().Format("2006_01_02_15_04_05"))
 
 
-	// initialize GLFW and OpenGL
-	if err := glfw.Init(); err != nil {
-		log.Fatalln("Failed to initialize glfw:", err)
-	}
-	defer glfw.Terminate()
+	// initialize GLFW and OpenGL, or only OpenGL on a surfaceless context in headless mode
+	var window *glfw.Window
+	if *headlessMode {
+		context, err := headless.NewContext(4, 5)
+		if err != nil {
+			log.Fatalln("Failed to create headless context", err)
+		}
+		defer context.Destroy()
+	} else {
+		if err := glfw.Init(); err != nil {
+			log.Fatalln("Failed to initialize glfw:", err)
+		}
+		defer glfw.Terminate()
 
-	glfw.WindowHint(glfw.ContextVersionMajor, 4)
-	glfw.WindowHint(glfw.ContextVersionMinor, 5)
-	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
-//	glfw.WindowHint(glfw.OpenGLDebugContext, glfw.True)
+		glfw.WindowHint(glfw.ContextVersionMajor, 4)
+		glfw.WindowHint(glfw.ContextVersionMinor, 5)
+		glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
+//		glfw.WindowHint(glfw.OpenGLDebugContext, glfw.True)
 
-	window, err := glfw.CreateWindow(initialWindowWidth, initialWindowHeight, "Gravity Simulation - Verlet Average", nil, nil)
-	if err != nil {
-		log.Fatalln("Failed to create window", err)
-	}
-	defer window.Destroy()
+		var err error
+		window, err = glfw.CreateWindow(initialWindowWidth, initialWindowHeight, "Gravity Simulation - Verlet Average", nil, nil)
+		if err != nil {
+			log.Fatalln("Failed to create window", err)
+		}
+		defer window.Destroy()
+
+		window.MakeContextCurrent()
 
-	window.MakeContextCurrent()
+		glfw.SwapInterval(0)
 
-	glfw.SwapInterval(0)
+		loadBookmarks()
+	}
 
 	if err := gl.Init(); err != nil {
 		log.Fatalln("Failed to initialize glow", err)
@@ -136,6 +288,29 @@ func main() {
 
 	log.Println("OpenGL version:", gl.GoStr(gl.GetString(gl.VERSION)))
 
+	if *substeps < 1 {
+		log.Fatalln("Invalid number of substeps", *substeps)
+	}
+	if *viewerMode && (*headlessMode || comoving) {
+		log.Fatalln("The viewer needs a window and a run in physical coordinates")
+	}
+
+
+	// offscreen recording of the rendered frames, independent of the window and also in headless mode
+	var recorder *recording.Recorder
+	recordingProjection := mgl.Perspective(math.Pi / 4, float32(*recordWidth) / float32(*recordHeight), 32.0, 88000.0)
+	if *recordPath != "" {
+		if *recordInterval < 1 {
+			log.Fatalln("Invalid recording interval", *recordInterval)
+		}
+		var err error
+		recorder, err = recording.NewRecorder(*recordPath, *recordWidth, *recordHeight, *recordFramesPerSecond)
+		if err != nil {
+			log.Fatalln("Could not create recording", err)
+		}
+		defer recorder.Close()
+	}
+
 
 	// set up OpenGL shaders and programs
 	{
@@ -187,34 +362,36 @@ func main() {
 	}
 
 
-	// initialize queries and shader/program uniforms and set up callbacks that might change them during runtime
+	// initialize queries and shader/program uniforms and set up callbacks that might change them during runtime; the
+	// projection of the window is kept to restore it after rendering into a recording of another aspect ratio
+	var projection mgl.Mat4
 	{
-		projection := mgl.Perspective(
+		projection = mgl.Perspective(
 			math.Pi / 4,
 			float32(initialWindowWidth) / float32(initialWindowHeight),
 			32.0,
 			88000.0,
 		)
-		sphereProgramProjection := gl.GetUniformLocation(sphereProgram, gl.Str("projection\x00"))
-		axisProgramProjection := gl.GetUniformLocation(axisProgram, gl.Str("projection\x00"))
-		gl.ProgramUniformMatrix4fv(sphereProgram, sphereProgramProjection, 1, false, &projection[0])
-		gl.ProgramUniformMatrix4fv(axisProgram, axisProgramProjection, 1, false, &projection[0])
-
-		window.SetFramebufferSizeCallback(
-			func(_ *glfw.Window, width, height int) {
-				gl.Viewport(0, 0, int32(width), int32(height))
-
-				projection := mgl.Perspective(
-					math.Pi / 4 * (float32(height) / float32(initialWindowHeight)),
-					float32(width) / float32(height),
-					32.0,
-					88000.0,
-				)
-
-				gl.ProgramUniformMatrix4fv(sphereProgram, sphereProgramProjection, 1, false, &projection[0])
-				gl.ProgramUniformMatrix4fv(axisProgram, axisProgramProjection, 1, false, &projection[0])
-			},
-		)
+		sphereProgramProjection = gl.GetUniformLocation(sphereProgram, gl.Str("projection\x00"))
+		axisProgramProjection = gl.GetUniformLocation(axisProgram, gl.Str("projection\x00"))
+		setProjection(projection)
+
+		if window != nil {
+			window.SetFramebufferSizeCallback(
+				func(_ *glfw.Window, width, height int) {
+					gl.Viewport(0, 0, int32(width), int32(height))
+
+					projection = mgl.Perspective(
+						math.Pi / 4 * (float32(height) / float32(initialWindowHeight)),
+						float32(width) / float32(height),
+						32.0,
+						88000.0,
+					)
+
+					setProjection(projection)
+				},
+			)
+		}
 
 		view := mgl.LookAtV(camera.root, camera.watch, mgl.Vec3{0, 1, 0})
 		sphereProgramView = gl.GetUniformLocation(sphereProgram, gl.Str("view\x00"))
@@ -235,55 +412,131 @@ func main() {
 
 		sphereProgramCameraLocation = gl.GetUniformLocation(sphereProgram, gl.Str("camera_location\x00"))
 		gl.ProgramUniform3fv(sphereProgram, sphereProgramCameraLocation, 1, &camera.root[0])
+
+		sphereProgramInterpolation = gl.GetUniformLocation(sphereProgram, gl.Str("interpolation\x00"))
 	}
 
 
 	// set up callbacks for camera movement
-	window.SetKeyCallback(
-		func(_ *glfw.Window, key glfw.Key, _ int, action glfw.Action, _ glfw.ModifierKey) {
-			switch key {
-			case glfw.KeyA:
-				switch action {
-				case glfw.Press:
-					leftKeyPressed = true
-					leftKeyOn = true
-				case glfw.Release:
-					leftKeyOn = false
-				}
-			case glfw.KeyD:
-				switch action {
-				case glfw.Press:
-					rightKeyPressed = true
-					rightKeyOn = true
-				case glfw.Release:
-					rightKeyOn = false
-				}
-			case glfw.KeyW:
-				switch action {
-				case glfw.Press:
-					upKeyPressed = true
-					upKeyOn = true
-				case glfw.Release:
-					upKeyOn = false
+	if window != nil {
+		window.SetKeyCallback(
+			func(_ *glfw.Window, key glfw.Key, _ int, action glfw.Action, mods glfw.ModifierKey) {
+				switch key {
+				case glfw.KeyA:
+					switch action {
+					case glfw.Press:
+						leftKeyPressed = true
+						leftKeyOn = true
+					case glfw.Release:
+						leftKeyOn = false
+					}
+				case glfw.KeyD:
+					switch action {
+					case glfw.Press:
+						rightKeyPressed = true
+						rightKeyOn = true
+					case glfw.Release:
+						rightKeyOn = false
+					}
+				case glfw.KeyW:
+					switch action {
+					case glfw.Press:
+						upKeyPressed = true
+						upKeyOn = true
+					case glfw.Release:
+						upKeyOn = false
+					}
+				case glfw.KeyS:
+					switch action {
+					case glfw.Press:
+						downKeyPressed = true
+						downKeyOn = true
+					case glfw.Release:
+						downKeyOn = false
+					}
+				case glfw.KeySpace:
+					if action == glfw.Press {
+						pauseKeyPressed = true
+					}
+				case glfw.KeyN:
+					if action == glfw.Press {
+						stepKeyPressed = true
+					}
+				case glfw.KeyEqual, glfw.KeyKPAdd:
+					if action == glfw.Press {
+						fasterKeyPressed = true
+					}
+				case glfw.KeyMinus, glfw.KeyKPSubtract:
+					if action == glfw.Press {
+						slowerKeyPressed = true
+					}
+				case glfw.KeyR:
+					if action == glfw.Press {
+						reverseKeyPressed = true
+					}
+				case glfw.KeyF:
+					if action == glfw.Press {
+						freeFly = !freeFly
+						cameraFlyVelocity, cameraYawVelocity, cameraPitchVelocity, cameraZoomVelocity = mgl.Vec3{}, 0, 0, 0
+					}
+				case glfw.KeyO:
+					if action == glfw.Press {
+						if followedOrb < 0 {
+							followedOrb, followCenterOfMass = *followIndex, false
+						} else {
+							followedOrb = -1
+						}
+					}
+				case glfw.KeyC:
+					if action == glfw.Press {
+						followCenterOfMass, followedOrb = !followCenterOfMass, -1
+					}
+				case glfw.KeyLeftBracket, glfw.KeyRightBracket:
+					if action == glfw.Press && followedOrb >= 0 {
+						if key == glfw.KeyLeftBracket && followedOrb > 0 {
+							followedOrb -= 1
+						} else if key == glfw.KeyRightBracket && followedOrb < numFollowableOrbs - 1 {
+							followedOrb += 1
+						}
+						fmt.Println("\nFollowing orb", followedOrb)
+					}
+				case glfw.Key1, glfw.Key2, glfw.Key3, glfw.Key4, glfw.Key5, glfw.Key6, glfw.Key7, glfw.Key8, glfw.Key9:
+					if action == glfw.Press {
+						if mods & glfw.ModControl != 0 {
+							saveBookmark(int(key - glfw.Key0))
+						} else {
+							recallBookmark(int(key - glfw.Key0))
+						}
+					}
 				}
-			case glfw.KeyS:
-				switch action {
-				case glfw.Press:
-					downKeyPressed = true
-					downKeyOn = true
-				case glfw.Release:
-					downKeyOn = false
+			},
+		)
+
+		window.SetScrollCallback(
+			func(_ *glfw.Window, xOffset, yOffset float64) {
+				scrollDirection -= float32(yOffset)
+				scrolling = true
+			},
+		)
+
+		window.SetMouseButtonCallback(
+			func(_ *glfw.Window, button glfw.MouseButton, action glfw.Action, _ glfw.ModifierKey) {
+				if button == glfw.MouseButtonLeft {
+					dragging = action == glfw.Press
 				}
-			}
-		},
-	)
+			},
+		)
 
-	window.SetScrollCallback(
-		func(_ *glfw.Window, xOffset, yOffset float64) {
-			scrollDirection -= float32(yOffset)
-			scrolling = true
-		},
-	)
+		window.SetCursorPosCallback(
+			func(_ *glfw.Window, x, y float64) {
+				if dragging {
+					cursorDeltaX += float32(x - cursorX)
+					cursorDeltaY += float32(y - cursorY)
+				}
+				cursorX, cursorY = x, y
+			},
+		)
+	}
 
 
 	// set up coordinate axis render prerequisites
@@ -408,19 +661,64 @@ func main() {
 	}
 
 
+	// copy external potentials into a shader storage buffer for use by the compute shaders
+	if len(externalPotentials) > 0 {
+		gl.CreateBuffers(1, &gravityExternalPotentialBuffer)
+		{
+			shdr := (*reflect.SliceHeader)(unsafe.Pointer(&externalPotentials))
+			gl.NamedBufferStorage(gravityExternalPotentialBuffer, len(externalPotentials) * 4 * 4, unsafe.Pointer(shdr.Data), 0)
+		}
+		gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 5, gravityExternalPotentialBuffer)
+	}
+
+
+	// time series of the conserved quantities of all runs, see profilingInterval
+	timeSeriesFileName := strings.TrimSuffix(profilingFileName, ".csv") + "-timeseries.csv"
+	timeSeriesFile, err := os.Create(timeSeriesFileName)
+	if err != nil {
+		log.Fatalln("Could not create", timeSeriesFileName, err)
+	}
+	defer timeSeriesFile.Close()
+	// metrics of every run that ran to completion
+	runsFileName := strings.TrimSuffix(profilingFileName, ".csv") + "-runs.csv"
+	runsFile, err := os.Create(runsFileName)
+	if err != nil {
+		log.Fatalln("Could not create", runsFileName, err)
+	}
+	defer runsFile.Close()
+	fmt.Fprintf(runsFile, "run, status, %s\n", strings.Join(nbody.MetricNames, ", "))
+
+	fmt.Fprintln(timeSeriesFile, "run, step, time, angular_momentum_x, angular_momentum_y, angular_momentum_z, energy, force_x, force_y, force_z, linear_momentum_x, linear_momentum_y, linear_momentum_z, center_of_mass_x, center_of_mass_y, center_of_mass_z")
+
+	// close encounters and binaries of all runs, see encounterInterval
+	var eventsFile *os.File
+	if encounterInterval > 0 {
+		eventsFileName := strings.TrimSuffix(profilingFileName, ".csv") + "-events.csv"
+		eventsFile, err = os.Create(eventsFileName)
+		if err != nil {
+			log.Fatalln("Could not create", eventsFileName, err)
+		}
+		defer eventsFile.Close()
+		fmt.Fprintln(eventsFile, "run, time, event, i, j, pericentre, relative_velocity, semi_major_axis, eccentricity")
+	}
+
+
 	// profiling loops
-	profilingLog = make([]ConservedQuantities, 3)
+	profilingLog = make([]nbody.Conserved, 2)
+	var runMetrics [][]float64 = make([][]float64, len(nbody.MetricNames))
+	var excludedRuns int
 	var numSpheres int = 32768
 	var localWorkGroupSize uint32 = 128
 	var globalWorkGroupSize uint32
-	for run := 0; run < numProfilingRuns && !window.ShouldClose(); run++ {
+	for run := 0; run < *numProfilingRuns && !windowClosed(window); run++ {
 		globalWorkGroupSize = uint32(numSpheres) / localWorkGroupSize
+		numFollowableOrbs = numSpheres
 		if uint32(numSpheres) % localWorkGroupSize != 0 {
 			globalWorkGroupSize += 1
 		}
 
 
-		fmt.Printf("Run: %v/%v\n", run + 1, numProfilingRuns)
+		fmt.Printf("Run: %v/%v\n", run + 1, *numProfilingRuns)
 
 
 		{
@@ -430,6 +728,7 @@ func main() {
 				localWorkGroupSize,
 				uint32(numSpheres),
 				globalWorkGroupSize,
+				computeShaderPreamble(),
 			)
 			if err != nil {
 				log.Fatalln(err)
@@ -438,6 +737,8 @@ func main() {
 			if err != nil {
 				log.Fatalln(err)
 			}
+			gravityProgramSimulationTime = gl.GetUniformLocation(gravityProgram, gl.Str("simulation_time\x00"))
+			gravityProgramStepFactors = getStepFactorUniforms(gravityProgram)
 			gl.DeleteShader(computeShader)
 		}
 
@@ -448,6 +749,7 @@ func main() {
 				localWorkGroupSize,
 				uint32(numSpheres),
 				globalWorkGroupSize,
+				computeShaderPreamble(),
 			)
 			if err != nil {
 				log.Fatalln(err)
@@ -456,6 +758,7 @@ func main() {
 			if err != nil {
 				log.Fatalln(err)
 			}
+			gravityStartupProgramStepFactors = getStepFactorUniforms(gravityStartupProgram)
 			gl.DeleteShader(computeShader)
 		}
 
@@ -466,6 +769,7 @@ func main() {
 				localWorkGroupSize,
 				uint32(numSpheres),
 				globalWorkGroupSize,
+				computeShaderPreamble(),
 			)
 			if err != nil {
 				log.Fatalln(err)
@@ -474,6 +778,8 @@ func main() {
 			if err != nil {
 				log.Fatalln(err)
 			}
+			profilingProgramSimulationTime = gl.GetUniformLocation(profilingProgram, gl.Str("simulation_time\x00"))
+			profilingProgramStepFactors = getStepFactorUniforms(profilingProgram)
 			gl.DeleteShader(computeShader)
 		}
 
@@ -529,7 +835,10 @@ func main() {
 				rand.Float32() - 0.5,
 			}.Normalize().Mul(1000.0 + rand.Float32() * 21000.0)
 
-			orbLocations[i].mass = float32(math.Pow10(rand.Intn(3))) * rand.Float32()
+			// tracers only feel the gravity of the massive orbs, which lets the compute shaders skip them as sources
+			if numMassiveOrbs == 0 || i < numMassiveOrbs {
+				orbLocations[i].mass = float32(math.Pow10(rand.Intn(3))) * rand.Float32()
+			}
 
 			orbMassLocations[i] = orbLocations[i].location.Mul(orbLocations[i].mass)
 
@@ -547,6 +856,11 @@ func main() {
 			// velocity magnitude
 			mag := ((sumOrbMass - orbLocations[i].mass) / sumOrbMass) * float32(math.Sqrt(float64((G * sumOrbMass) / dv.Len())))
 
+			// the external potentials add their own centripetal acceleration
+			if inward := -externalAcceleration(orbLocations[i].location, 0).Dot(orbLocations[i].location); inward > 0 {
+				mag = float32(math.Sqrt(float64(mag * mag + inward)))
+			}
+
 			// velocity direction
 			dir := dv.Cross(mgl.Vec3{0, 1, 0}).Normalize()
 
@@ -554,6 +868,11 @@ func main() {
 			orbVelocities[i].velocity = dir.Mul(mag)
 		}
 
+		// cosmological runs start from a uniform sphere instead of the disk
+		if comoving {
+			orbLocations, orbVelocities = newComovingSphere(numSpheres)
+		}
+
 
 		// no need to populate this buffer since the first compute dispatch stores the newly calculated positions here anyway
 		gl.DeleteBuffers(1, &gravityLocationBuffer0)
@@ -581,59 +900,110 @@ func main() {
 		}
 		gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 2, gravityVelocityBuffer)
 
+		// per-orb softening lengths grow with the cube root of the mass, like the radius of an orb of constant density
+		if perParticleSoftening {
+			var softenings []float32 = make([]float32, numSpheres)
+			for i := range softenings {
+				softenings[i] = softeningLength * float32(math.Cbrt(float64(orbLocations[i].mass / softeningReferenceMass)))
+			}
+
+			gl.DeleteBuffers(1, &gravitySofteningBuffer)
+			gl.CreateBuffers(1, &gravitySofteningBuffer)
+			{
+				// populate new buffer with data from data pointer of slice variable 'softenings'
+				shdr := (*reflect.SliceHeader)(unsafe.Pointer(&softenings))
+				gl.NamedBufferStorage(gravitySofteningBuffer, numSpheres * 4, unsafe.Pointer(shdr.Data), 0)
+			}
+			gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 4, gravitySofteningBuffer)
+		}
+
+		// the central orb carries a positive charge, all other orbs a charge of random sign, both proportional to the mass
+		if forceLaw.Charged() {
+			var charges []float32 = make([]float32, numSpheres)
+			for i := range charges {
+				charges[i] = chargePerMass * orbLocations[i].mass
+				if i > 0 && rand.Intn(2) == 0 {
+					charges[i] = -charges[i]
+				}
+			}
+
+			gl.DeleteBuffers(1, &gravityChargeBuffer)
+			gl.CreateBuffers(1, &gravityChargeBuffer)
+			{
+				// populate new buffer with data from data pointer of slice variable 'charges'
+				shdr := (*reflect.SliceHeader)(unsafe.Pointer(&charges))
+				gl.NamedBufferStorage(gravityChargeBuffer, numSpheres * 4, unsafe.Pointer(shdr.Data), 0)
+			}
+			gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 6, gravityChargeBuffer)
+		}
+
 		gl.DeleteBuffers(1, &profileResultsBuffer)
 		gl.CreateBuffers(1, &profileResultsBuffer)
-		gl.NamedBufferStorage(profileResultsBuffer, int(globalWorkGroupSize) * 4 * 4 * 2, nil, gl.MAP_READ_BIT)
+		gl.NamedBufferStorage(profileResultsBuffer, int(globalWorkGroupSize) * 4 * 4 * 5, nil, gl.MAP_READ_BIT)
 		gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 3, profileResultsBuffer)
 
-
-		gl.UseProgram(gravityStartupProgram)
-		gl.DispatchCompute(globalWorkGroupSize, 1, 1)
-		gl.UseProgram(0)
+		// comoving runs step through a schedule of scale factors, whose drift and kick factors take the place of deltaT
+		var scaleFactors []float64
+		var snapshotSteps []int
+		// outside comoving runs every step drifts and kicks by deltaT, readOrbs divides by the last drift
+		var lastDrift, lastKick float32 = deltaT, deltaT
+		if comoving {
+			scaleFactors, snapshotSteps = nbody.ScaleFactorSchedule(
+				nbody.ScaleFactor(initialRedshift),
+				nbody.ScaleFactor(finalRedshift),
+				numFrames + 1,
+				snapshotScaleFactors(),
+			)
+			lastDrift = float32(cosmology.DriftFactor(scaleFactors[0], scaleFactors[1]))
+			lastKick = float32(cosmology.KickFactor(scaleFactors[0], scaleFactors[1]))
+			// the startup step is the first one of the schedule
+			gravityStartupProgramStepFactors.set(gravityStartupProgram, lastDrift, lastKick, lastDrift)
+			profilingProgramStepFactors.set(profilingProgram, lastDrift, lastKick, lastDrift)
+		}
 
 
-		gl.UseProgram(profilingProgram)
+		gl.UseProgram(gravityStartupProgram)
 		gl.DispatchCompute(globalWorkGroupSize, 1, 1)
 		gl.UseProgram(0)
-		gl.Finish()
 
 
-		{
-			var shdr *reflect.SliceHeader
-			var results []ConservedQuantities
-			shdr = (*reflect.SliceHeader)(unsafe.Pointer(&results))
-			shdr.Data = (uintptr)(gl.MapNamedBuffer(profileResultsBuffer, gl.READ_ONLY))
-			shdr.Len = int(globalWorkGroupSize)
-			shdr.Cap = int(globalWorkGroupSize)
-			for i, result := range results {
-				if i == 0 {
-					profilingLog[0].angularMomentum = result.angularMomentum
-					profilingLog[0].totalEnergy = result.totalEnergy
-					profilingLog[0].totalForce = result.totalForce
-				} else {
-					profilingLog[0].angularMomentum = profilingLog[0].angularMomentum.Add(result.angularMomentum)
-					profilingLog[0].totalEnergy += result.totalEnergy
-					profilingLog[0].totalForce = profilingLog[0].totalForce.Add(result.totalForce)
-				}
-			}
-			gl.UnmapNamedBuffer(profileResultsBuffer)
+		// state of all orbs at the last passed check, see checkInterval
+		var lastValidOrbs []nbody.Body
+		var lastValidStep int
+		var lastValidTime float32
 
-			profilingLog[0].totalForceMagnitude += profilingLog[0].totalForce.Len() * 0.01
+		// close encounters are followed from one readback to the next
+		detector := nbody.EncounterDetector{
+			G: G,
+			Distance: encounterDistance,
+			BinaryDistance: binaryDistance,
+			MinBinaryTime: minBinaryTime,
+			SkipCentral: true,
 		}
 
+		profilingLog[0] = profile(globalWorkGroupSize, 0)
+		writeTimeSeriesRow(timeSeriesFile, run + 1, 0, 0, profilingLog[0])
+
 
 		// main loop; breaks when profiling is done
+		var simulationTime float32
+		var timeDirection float32 = 1
+		var paused bool
 		var frameCounter uint32
 		var timeSinceLastSecond, loopTimeStart, loopTimeElapsed, sumTimePerFrame float64
 		var locationBuffer1Active bool
 		var progressBar string = ""
+		realTimeStart := realTimeStartAt(0)
+		status := "completed"		// or failed by drifting too far, or crashed by invalid orbs
+		numSteps := 0		// steps done, the loop counter misses the last one of a run stopped by a check
+		loggedStep := 0		// last step written to the time series
 		i := 0
-		for ; i < numFrames && !window.ShouldClose(); i++ {
-			loopTimeStart = glfw.GetTime()
+		for ; (*viewerMode || i < numFrames) && !windowClosed(window); i++ {
+			loopTimeStart = seconds()
 
 
-			// FPS counter and progress bar, displays FPS every second
-			if i % 25 == 0 {
+			// step counter and progress bar, displays steps per second every second
+			if i % 25 == 0 && len(progressBar) < 40 {
 				progressBar += "="
 			}
 
@@ -642,195 +1012,717 @@ func main() {
 			frameCounter += 1
 			if timeSinceLastSecond > 1 {
 			    timeSinceLastSecond = 0
-				fmt.Printf("[%-40s] %4d/%4d Frames; %4d FPS\r", progressBar, i + 1, numFrames, frameCounter)
+				// the viewer runs without a limit of steps to show progress towards
+				if *viewerMode {
+					fmt.Printf("%4d Steps; %4d Steps/s\r", i + 1, frameCounter)
+				} else {
+					fmt.Printf("[%-40s] %4d/%4d Steps; %4d Steps/s\r", progressBar, i + 1, numFrames, frameCounter)
+				}
 			    frameCounter = 0
 			}
 
 
 			// GLFW event handling
-			glfw.PollEvents()
+			if window != nil {
+				glfw.PollEvents()
+			}
+
+
+			// viewer controls; while paused the window shows the current state until a single step or resume
+			if *viewerMode {
+				for paused && !stepKeyPressed && !pauseKeyPressed && !windowClosed(window) {
+					// the last step swapped the current locations into those of the last state
+					presentFrame(window, numSpheres, locationBuffer1Active, 0)
+					glfw.PollEvents()
+				}
+				if paused {
+					realTimeStart = realTimeStartAt(i)
+				}
+				if pauseKeyPressed {
+					paused = !paused
+					pauseKeyPressed = false
+				}
+				stepKeyPressed = false
+
+				if fasterKeyPressed || slowerKeyPressed {
+					changeSpeed(fasterKeyPressed)
+					fasterKeyPressed, slowerKeyPressed = false, false
+					realTimeStart = realTimeStartAt(i)
+				}
+
+				// swapping the current and the last locations steps back and turns the direction of time
+				if reverseKeyPressed {
+					locationBuffer1Active = !locationBuffer1Active
+					bindLocationBuffers(locationBuffer1Active)
+					simulationTime -= timeDirection * deltaT
+					timeDirection = -timeDirection
+					reverseKeyPressed = false
+				}
+			}
 
 
 			// use compute shader to update sphere positions
+			if comoving {
+				drift := float32(cosmology.DriftFactor(scaleFactors[i + 1], scaleFactors[i + 2]))
+				kick := float32(cosmology.KickFactor(scaleFactors[i + 1], scaleFactors[i + 2]))
+				gravityProgramStepFactors.set(gravityProgram, drift, kick, lastDrift)
+				lastDrift, lastKick = drift, kick
+			}
+			gl.ProgramUniform1f(gravityProgram, gravityProgramSimulationTime, simulationTime)
 			gl.UseProgram(gravityProgram)
 			gl.DispatchCompute(globalWorkGroupSize, 1, 1)
 			gl.UseProgram(0)
+			simulationTime += timeDirection * deltaT
+			numSteps = i + 1
 
 
-			// input handling with GLFW events, determine movement direction, then move camera accordingly
-			if (leftKeyPressed || leftKeyOn) && !(rightKeyPressed || rightKeyOn) {
-				moveLeft = true
-			} else if (rightKeyPressed || rightKeyOn) && !(leftKeyPressed || leftKeyOn) {
-				moveRight = true
-			}
-
-			leftKeyPressed, rightKeyPressed = false, false
 
-			if (upKeyPressed || upKeyOn) && !(downKeyPressed || downKeyOn) {
-				dot := mgl.Vec3{0, 1, 0}.Dot(camera.root.Normalize())
-				if dot < 0.99 {
-					moveUp = true
+			// rendering into the window after every substeps steps, skipped in headless mode; in real-time mode frames
+			// interpolated between the last two states fill the time until the wall clock catches up with the simulation
+			if window != nil {
+				lead := realTimeLead(i + 1, realTimeStart)
+				if lead <= 0 && (i + 1) % *substeps == 0 {
+					presentFrame(window, numSpheres, locationBuffer1Active, 1)
 				}
-			} else if (downKeyPressed || downKeyOn) && !(upKeyPressed || upKeyOn) {
-				dot := mgl.Vec3{0, -1, 0}.Dot(camera.root.Normalize())
-				if dot < 0.99 {
-					moveDown = true
+				for ; lead > 0 && !windowClosed(window); lead = realTimeLead(i + 1, realTimeStart) {
+					presentFrame(window, numSpheres, locationBuffer1Active, 1 - float32(math.Min(lead / deltaT, 1)))
+					glfw.PollEvents()
 				}
 			}
 
-			upKeyPressed, downKeyPressed = false, false
-
-			if moveLeft {
-				rotate := mgl.HomogRotate3D(-cameraRotationDistancePerFrame, mgl.Vec3{0, 1, 0})
-				camera.root = rotate.Mul4x1(camera.root.Vec4(1)).Vec3()
-				view := mgl.LookAtV(camera.root, camera.watch, mgl.Vec3{0, 1, 0})
-				gl.ProgramUniformMatrix4fv(sphereProgram, sphereProgramView, 1, false, &view[0])
-				gl.ProgramUniformMatrix4fv(axisProgram, axisProgramView, 1, false, &view[0])
-				gl.ProgramUniform3fv(sphereProgram, sphereProgramCameraLocation, 1, &camera.root[0])
-			} else if moveRight {
-				rotate := mgl.HomogRotate3D(cameraRotationDistancePerFrame, mgl.Vec3{0, 1, 0})
-				camera.root = rotate.Mul4x1(camera.root.Vec4(1)).Vec3()
-				view := mgl.LookAtV(camera.root, camera.watch, mgl.Vec3{0, 1, 0})
-				gl.ProgramUniformMatrix4fv(sphereProgram, sphereProgramView, 1, false, &view[0])
-				gl.ProgramUniformMatrix4fv(axisProgram, axisProgramView, 1, false, &view[0])
-				gl.ProgramUniform3fv(sphereProgram, sphereProgramCameraLocation, 1, &camera.root[0])
+			// rendering into the recording every recordInterval steps
+			if recorder != nil && i % *recordInterval == 0 {
+				gl.ProgramUniform1f(sphereProgram, sphereProgramInterpolation, 1)
+				setProjection(recordingProjection)
+				recorder.Begin()
+				renderScene(numSpheres)
+				if err := recorder.End(); err != nil {
+					log.Fatalln(err)
+				}
+				setProjection(projection)
 			}
 
-			moveLeft, moveRight = false, false
-
-			if moveUp {
-				axis := mgl.Vec3{0, 1, 0}.Cross(camera.root).Normalize()
-				rotate := mgl.HomogRotate3D(-cameraRotationDistancePerFrame, axis)
-				camera.root = rotate.Mul4x1(camera.root.Vec4(1)).Vec3()
-				view := mgl.LookAtV(camera.root, camera.watch, mgl.Vec3{0, 1, 0})
-				gl.ProgramUniformMatrix4fv(sphereProgram, sphereProgramView, 1, false, &view[0])
-				gl.ProgramUniformMatrix4fv(axisProgram, axisProgramView, 1, false, &view[0])
-				gl.ProgramUniform3fv(sphereProgram, sphereProgramCameraLocation, 1, &camera.root[0])
-			} else if moveDown {
-				axis := mgl.Vec3{0, 1, 0}.Cross(camera.root).Normalize()
-				rotate := mgl.HomogRotate3D(cameraRotationDistancePerFrame, axis)
-				camera.root = rotate.Mul4x1(camera.root.Vec4(1)).Vec3()
-				view := mgl.LookAtV(camera.root, camera.watch, mgl.Vec3{0, 1, 0})
-				gl.ProgramUniformMatrix4fv(sphereProgram, sphereProgramView, 1, false, &view[0])
-				gl.ProgramUniformMatrix4fv(axisProgram, axisProgramView, 1, false, &view[0])
-				gl.ProgramUniform3fv(sphereProgram, sphereProgramCameraLocation, 1, &camera.root[0])
+			if locationBuffer1Active {
+				gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 0, gravityLocationBuffer0)
+				gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 1, gravityLocationBuffer1)
+			} else {
+				gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 0, gravityLocationBuffer1)
+				gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 1, gravityLocationBuffer0)
 			}
+			locationBuffer1Active = !locationBuffer1Active
 
-			moveUp, moveDown = false, false
-
-			if scrolling {
-				scroll := camera.root.Normalize().Mul(scrollDirection * 2096.0)
-				newRoot := camera.root.Add(scroll)
-				newRootLength := newRoot.Len()
-				if newRootLength > 1024.0 && newRootLength < 44000.0 {
-					camera.root = newRoot
-					view := mgl.LookAtV(camera.root, camera.watch, mgl.Vec3{0, 1, 0})
-					gl.ProgramUniformMatrix4fv(sphereProgram, sphereProgramView, 1, false, &view[0])
-					gl.ProgramUniformMatrix4fv(axisProgram, axisProgramView, 1, false, &view[0])
-					gl.ProgramUniform3fv(sphereProgram, sphereProgramCameraLocation, 1, &camera.root[0])
+			// write a snapshot whenever the scale factor of one of the requested redshifts is reached
+			if len(snapshotSteps) > 0 && snapshotSteps[0] == i + 2 {
+				snapshotSteps = snapshotSteps[1:]
+				locationBuffer, lastLocationBuffer := locationBuffers(locationBuffer1Active)
+				writeComovingSnapshot(
+					fmt.Sprintf("run%v", run + 1),
+					readOrbs(numSpheres, locationBuffer, lastLocationBuffer, lastDrift),
+					scaleFactors[i + 2],
+				)
+			}
+
+			// snapshots of all orbs for the analysis tools, every snapshotInterval frames
+			if !comoving && snapshotInterval > 0 && (i + 1) % snapshotInterval == 0 {
+				locationBuffer, lastLocationBuffer := locationBuffers(locationBuffer1Active)
+				writeSnapshot(
+					fmt.Sprintf("run%v", run + 1),
+					i + 1,
+					simulationTime,
+					readOrbs(numSpheres, locationBuffer, lastLocationBuffer, lastDrift),
+				)
+			}
+
+			// stop the run at the first non-finite or runaway orb, every checkInterval frames
+			if checkInterval > 0 && (i + 1) % checkInterval == 0 {
+				locationBuffer, lastLocationBuffer := locationBuffers(locationBuffer1Active)
+				orbs := readOrbs(numSpheres, locationBuffer, lastLocationBuffer, lastDrift)
+				if invalid := nbody.InvalidBodies(orbs, maxDistance); len(invalid) > 0 {
+					reportInvalidOrbs(fmt.Sprintf("run%v", run + 1), i + 1, simulationTime, invalid, orbs, lastValidOrbs, lastValidStep, lastValidTime)
+					status = "crashed"
+					break
 				}
+				lastValidOrbs, lastValidStep, lastValidTime = orbs, i + 1, simulationTime
+			}
 
+			// close encounters and binaries, every encounterInterval frames
+			if !comoving && encounterInterval > 0 && (i + 1) % encounterInterval == 0 {
+				locationBuffer, lastLocationBuffer := locationBuffers(locationBuffer1Active)
+				writeEvents(eventsFile, run + 1, detector.Update(readOrbs(numSpheres, locationBuffer, lastLocationBuffer, lastDrift), float64(simulationTime)))
 			}
 
-			scrollDirection = 0
-			scrolling = false
+			// conserved quantities over time, every profilingInterval frames
+			if profilingInterval > 0 && (i + 1) % profilingInterval == 0 {
+				if comoving {
+					profilingProgramStepFactors.set(profilingProgram, lastDrift, lastKick, lastDrift)
+				}
+				conserved := profile(globalWorkGroupSize, simulationTime)
+				writeTimeSeriesRow(timeSeriesFile, run + 1, i + 1, simulationTime, conserved)
+				loggedStep = i + 1
+
+				// stop the run once energy or angular momentum drifted too far
+				if drift := nbody.NewMetrics(profilingLog[0], conserved); !comoving && driftExceeded(drift) {
+					locationBuffer, lastLocationBuffer := locationBuffers(locationBuffer1Active)
+					reportDrift(fmt.Sprintf("run%v", run + 1), i + 1, simulationTime, drift, readOrbs(numSpheres, locationBuffer, lastLocationBuffer, lastDrift))
+					status = "failed"
+					break
+				}
+			}
 
 
-			// rendering
-			gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+			loopTimeElapsed = seconds() - loopTimeStart
+		}
+		averageStepsPerSecond := uint32(math.Round(1.0 / (sumTimePerFrame / float64(numSteps))))
+		if *viewerMode {
+			fmt.Printf("%4d Steps; %4d AVG Steps/s\n", numSteps, averageStepsPerSecond)
+		} else {
+			fmt.Printf("[%-40s] %4d/%4d Steps; %4d AVG Steps/s\n", progressBar, numSteps, numFrames, averageStepsPerSecond)
+		}
 
-			gl.UseProgram(axisProgram)
-			gl.BindVertexArray(axisVertexArray)
-			gl.DrawArraysInstanced(gl.LINES, 0, 6, 1)
-			gl.BindVertexArray(0)
-			gl.UseProgram(0)
 
-			gl.UseProgram(sphereProgram)
-			gl.BindVertexArray(sphereVertexArray)
-			gl.DrawElementsInstanced(gl.PATCHES, 24, gl.UNSIGNED_INT, nil, int32(numSpheres))
-			gl.BindVertexArray(0)
-			gl.UseProgram(0)
+		if comoving {
+			profilingProgramStepFactors.set(profilingProgram, lastDrift, lastKick, lastDrift)
+		}
+		profilingLog[1] = profile(globalWorkGroupSize, simulationTime)
+		if loggedStep != numSteps {
+			writeTimeSeriesRow(timeSeriesFile, run + 1, numSteps, simulationTime, profilingLog[1])
+		}
+		if !comoving && encounterInterval > 0 {
+			locationBuffer, lastLocationBuffer := locationBuffers(locationBuffer1Active)
+			writeEvents(eventsFile, run + 1, detector.Finish(readOrbs(numSpheres, locationBuffer, lastLocationBuffer, lastDrift), float64(simulationTime)))
+		}
 
-			if locationBuffer1Active {
-				gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 0, gravityLocationBuffer0)
-				gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 1, gravityLocationBuffer1)
-			} else {
-				gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 0, gravityLocationBuffer1)
-				gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 1, gravityLocationBuffer0)
+
+		// relative errors of this run, recorded if it ran to completion or was stopped by a failed check, but only
+		// completed runs enter the summary statistics
+		metrics := nbody.NewMetrics(profilingLog[0], profilingLog[1])
+		fmt.Println(metrics)
+		if (status == "completed" && numSteps < numFrames) || *viewerMode {
+			continue
+		}
+
+		row := []string{fmt.Sprint(run + 1), status}
+		for k, value := range metrics.Values() {
+			if status == "completed" {
+				runMetrics[k] = append(runMetrics[k], value)
 			}
-			locationBuffer1Active = !locationBuffer1Active
+			row = append(row, fmt.Sprint(value))
+		}
+		if status != "completed" {
+			excludedRuns += 1
+		}
+		_, err = fmt.Fprintln(runsFile, strings.Join(row, ", "))
+		if err != nil {
+			log.Fatalln("Could not write to", runsFileName, err)
+		}
+	}
 
-			window.SwapBuffers()
+	if excludedRuns > 0 {
+		fmt.Println("\nExcluded", excludedRuns, "failed or crashed runs from the summary statistics")
+	}
+	if len(runMetrics[0]) == 0 {
+		return
+	}
 
+	// write the summary statistics over all completed runs to filesystem
+	file, err := os.Create(profilingFileName)
+	if err != nil {
+		log.Fatalln("Could not create", profilingFileName, err)
+	}
+	defer file.Close()
+
+	fmt.Fprintf(file, "metric, %s\n", strings.Join(nbody.SummaryNames, ", "))
+	for k, name := range nbody.MetricNames {
+		summary := nbody.Summarize(runMetrics[k])
+		fmt.Printf("%s: mean %.3e, 95%% CI [%.3e, %.3e]\n", name, summary.Mean, summary.ConfidenceLow, summary.ConfidenceHigh)
 
-			loopTimeElapsed = glfw.GetTime() - loopTimeStart
+		row := []string{name}
+		for _, value := range summary.Values() {
+			row = append(row, fmt.Sprint(value))
 		}
-		fmt.Printf(
-			"[%-40s] %4d/%4d Frames; %4d AVG FPS\n",
-			progressBar,
-			i,
-			numFrames,
-			uint32(math.Round(1.0 / (sumTimePerFrame / float64(i)))),
-		)
+		_, err = fmt.Fprintln(file, strings.Join(row, ", "))
+		if err != nil {
+			log.Fatalln("Could not write to", profilingFileName, err)
+		}
+	}
+}
 
 
-		gl.UseProgram(profilingProgram)
-		gl.DispatchCompute(globalWorkGroupSize, 1, 1)
-		gl.UseProgram(0)
-		gl.Finish()
+// writeSnapshot writes the state of all orbs after step frames, the file names sort by step
+func writeSnapshot(label string, step int, simulationTime float32, orbs []nbody.Body) {
+	fileName := fmt.Sprintf("%s-%s-step%06d.csv", strings.TrimSuffix(profilingFileName, ".csv"), label, step)
 
+	// the velocities are the differences of the last two locations divided by the last drift, which must not be 0
+	if invalid := nbody.InvalidBodies(orbs, 0); len(invalid) > 0 {
+		log.Println("Snapshot", fileName, "has", len(invalid), "orbs with a location or velocity that is not finite")
+	}
+	err := nbody.WriteSnapshot(
+		fileName,
+		orbs,
+		map[string]string{
+			"step": fmt.Sprint(step),
+			"time": fmt.Sprint(simulationTime),
+			"G": fmt.Sprint(G),
+		},
+	)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
 
-		{
-			var shdr *reflect.SliceHeader
-			var results []ConservedQuantities
-			shdr = (*reflect.SliceHeader)(unsafe.Pointer(&results))
-			shdr.Data = (uintptr)(gl.MapNamedBuffer(profileResultsBuffer, gl.READ_ONLY))
-			shdr.Len = int(globalWorkGroupSize)
-			shdr.Cap = int(globalWorkGroupSize)
-			for i, result := range results {
-				if i == 0 {
-					profilingLog[1].angularMomentum = result.angularMomentum
-					profilingLog[1].totalEnergy = result.totalEnergy
-					profilingLog[1].totalForce = result.totalForce
-				} else {
-					profilingLog[1].angularMomentum = profilingLog[1].angularMomentum.Add(result.angularMomentum)
-					profilingLog[1].totalEnergy += result.totalEnergy
-					profilingLog[1].totalForce = profilingLog[1].totalForce.Add(result.totalForce)
-				}
-			}
-			gl.UnmapNamedBuffer(profileResultsBuffer)
 
-			profilingLog[1].totalForceMagnitude += profilingLog[1].totalForce.Len() * 0.01
+// writeEvents appends close encounters and binaries to the event log
+func writeEvents(file *os.File, id int, events []nbody.Event) {
+	for _, e := range events {
+		_, err := fmt.Fprintf(
+			file,
+			"%v, %v, %s, %v, %v, %v, %v, %v, %v\n",
+			id,
+			e.Time,
+			e.Kind,
+			e.I,
+			e.J,
+			e.Pericentre,
+			e.RelativeVelocity,
+			e.Elements.SemiMajorAxis,
+			e.Elements.Eccentricity,
+		)
+		if err != nil {
+			log.Fatalln("Could not write to", file.Name(), err)
 		}
+	}
+}
+
 
 
-		profilingLog[2].angularMomentum = profilingLog[2].angularMomentum.Add(profilingLog[0].angularMomentum.Sub(profilingLog[1].angularMomentum).Mul(0.01))
-		profilingLog[2].totalEnergy += (profilingLog[0].totalEnergy - profilingLog[1].totalEnergy) * 0.01
-		for _, row := range profilingLog {
-			fmt.Println(row)
+// reportInvalidOrbs prints the orbs that failed the check after step frames together with their state at the last
+// passed check, and writes that state of all orbs to a crash snapshot with its step and simulation time
+func reportInvalidOrbs(label string, step int, simulationTime float32, invalid []int, orbs, lastValidOrbs []nbody.Body, lastValidStep int, lastValidTime float32) {
+	fmt.Printf("\n%v orbs are not finite or farther than %v from the origin after %v frames\n", len(invalid), maxDistance, step)
+	for k, i := range invalid {
+		if k == 10 {
+			fmt.Printf("\t... and %v more\n", len(invalid) - k)
+			break
+		}
+		fmt.Printf("\torb %v: location %v, velocity %v\n", i, orbs[i].Position, orbs[i].Velocity)
+		if lastValidOrbs != nil {
+			fmt.Printf("\t\tafter %v frames: location %v, velocity %v\n", lastValidStep, lastValidOrbs[i].Position, lastValidOrbs[i].Velocity)
 		}
 	}
 
-	// write profiling measurements to filesystem
-	file, err := os.OpenFile(profilingFileName, os.O_WRONLY | os.O_CREATE | os.O_APPEND, 0666)
+	indices := make([]string, len(invalid))
+	for k, i := range invalid {
+		indices[k] = fmt.Sprint(i)
+	}
+	metadata := map[string]string{
+		"failed_step": fmt.Sprint(step),
+		"failed_time": fmt.Sprint(simulationTime),
+		"invalid_orbs": strings.Join(indices, " "),
+		"G": fmt.Sprint(G),
+	}
+
+	// without a passed check yet the failed state is all there is
+	crashOrbs := lastValidOrbs
+	metadata["step"] = fmt.Sprint(lastValidStep)
+	metadata["time"] = fmt.Sprint(lastValidTime)
+	if crashOrbs == nil {
+		crashOrbs = orbs
+		metadata["step"] = fmt.Sprint(step)
+		metadata["time"] = fmt.Sprint(simulationTime)
+	}
+
+	fileName := fmt.Sprintf("%s-%s-crash.csv", strings.TrimSuffix(profilingFileName, ".csv"), label)
+	if err := nbody.WriteSnapshot(fileName, crashOrbs, metadata); err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Println("Wrote crash snapshot", fileName)
+}
+
+
+
+// driftExceeded reports whether the errors of a run crossed maxEnergyError or maxAngularMomentumError, NaN errors
+// count as crossed
+func driftExceeded(drift nbody.Metrics) bool {
+	return (maxEnergyError > 0 && !(drift.EnergyError <= maxEnergyError)) ||
+		(maxAngularMomentumError > 0 && !(drift.AngularMomentumError <= maxAngularMomentumError))
+}
+
+
+// reportDrift prints the errors of a run that crossed a threshold after step frames and writes a snapshot of all
+// orbs that is marked as failed
+func reportDrift(label string, step int, simulationTime float32, drift nbody.Metrics, orbs []nbody.Body) {
+	fmt.Printf(
+		"\nenergy error %v (max %v), angular momentum error %v (max %v) after %v frames\n",
+		drift.EnergyError,
+		maxEnergyError,
+		drift.AngularMomentumError,
+		maxAngularMomentumError,
+		step,
+	)
+
+	fileName := fmt.Sprintf("%s-%s-failed.csv", strings.TrimSuffix(profilingFileName, ".csv"), label)
+	err := nbody.WriteSnapshot(
+		fileName,
+		orbs,
+		map[string]string{
+			"status": "failed",
+			"step": fmt.Sprint(step),
+			"time": fmt.Sprint(simulationTime),
+			"G": fmt.Sprint(G),
+			"energy_error": fmt.Sprint(drift.EnergyError),
+			"max_energy_error": fmt.Sprint(maxEnergyError),
+			"angular_momentum_error": fmt.Sprint(drift.AngularMomentumError),
+			"max_angular_momentum_error": fmt.Sprint(maxAngularMomentumError),
+		},
+	)
 	if err != nil {
-		log.Fatalln("Could not open '%s': %s", profilingFileName, err)
+		log.Fatalln(err)
 	}
+	fmt.Println("Wrote diagnostic snapshot", fileName)
+}
+
 
-	_, err = fmt.Fprintf(
+// dispatch the profiling compute shader and sum up the results of all work groups
+func profile(globalWorkGroupSize uint32, simulationTime float32) nbody.Conserved {
+	gl.ProgramUniform1f(profilingProgram, profilingProgramSimulationTime, simulationTime)
+	gl.UseProgram(profilingProgram)
+	gl.DispatchCompute(globalWorkGroupSize, 1, 1)
+	gl.UseProgram(0)
+	gl.MemoryBarrier(gl.BUFFER_UPDATE_BARRIER_BIT)
+	gl.Finish()
+
+	var sum nbody.Conserved
+	var shdr *reflect.SliceHeader
+	var results []ConservedQuantities
+	shdr = (*reflect.SliceHeader)(unsafe.Pointer(&results))
+	shdr.Data = (uintptr)(gl.MapNamedBuffer(profileResultsBuffer, gl.READ_ONLY))
+	shdr.Len = int(globalWorkGroupSize)
+	shdr.Cap = int(globalWorkGroupSize)
+	for _, result := range results {
+		sum.AngularMomentum = sum.AngularMomentum.Add(toVec3(result.angularMomentum))
+		sum.KineticEnergy += float64(result.kineticEnergy)
+		sum.PotentialEnergy += float64(result.potentialEnergy)
+		sum.TotalForce = sum.TotalForce.Add(toVec3(result.totalForce))
+		sum.ForceMagnitudes += float64(result.sumForceMagnitudes)
+		sum.LinearMomentum = sum.LinearMomentum.Add(toVec3(result.linearMomentum))
+		sum.Mass += float64(result.totalMass)
+		sum.CenterOfMass = sum.CenterOfMass.Add(toVec3(result.massLocation))
+	}
+	gl.UnmapNamedBuffer(profileResultsBuffer)
+
+	// the shader sums up mass weighted locations, without any mass the centre of mass stays at the origin
+	if sum.Mass > 0 {
+		sum.CenterOfMass = sum.CenterOfMass.Mul(1 / sum.Mass)
+		centerOfMass = mgl.Vec3{float32(sum.CenterOfMass[0]), float32(sum.CenterOfMass[1]), float32(sum.CenterOfMass[2])}
+	}
+
+	return sum
+}
+
+
+// append a row to the time series, id identifies the run the row belongs to
+func writeTimeSeriesRow(file *os.File, id, step int, simulationTime float32, conserved nbody.Conserved) {
+	_, err := fmt.Fprintf(
 		file,
-		"%v, %v, %v, %v, %v, %v\n",
-		mgl.Abs(profilingLog[2].angularMomentum.X()),
-		mgl.Abs(profilingLog[2].angularMomentum.Y()),
-		mgl.Abs(profilingLog[2].angularMomentum.Z()),
-		mgl.Abs(profilingLog[2].totalEnergy),
-		profilingLog[0].totalForceMagnitude,
-		profilingLog[1].totalForceMagnitude,
+		"%v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v\n",
+		id,
+		step,
+		simulationTime,
+		conserved.AngularMomentum[0],
+		conserved.AngularMomentum[1],
+		conserved.AngularMomentum[2],
+		conserved.Energy(),
+		conserved.TotalForce[0],
+		conserved.TotalForce[1],
+		conserved.TotalForce[2],
+		conserved.LinearMomentum[0],
+		conserved.LinearMomentum[1],
+		conserved.LinearMomentum[2],
+		conserved.CenterOfMass[0],
+		conserved.CenterOfMass[1],
+		conserved.CenterOfMass[2],
 	)
 	if err != nil {
-		log.Fatalln("Could not write to '%s': %s", profilingFileName, err)
+		log.Fatalln("Could not write to", file.Name(), err)
+	}
+}
+
+
+// draw the coordinate axes and all orbs into the bound framebuffer
+func renderScene(numSpheres int) {
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+
+	gl.UseProgram(axisProgram)
+	gl.BindVertexArray(axisVertexArray)
+	gl.DrawArraysInstanced(gl.LINES, 0, 6, 1)
+	gl.BindVertexArray(0)
+	gl.UseProgram(0)
+
+	gl.UseProgram(sphereProgram)
+	gl.BindVertexArray(sphereVertexArray)
+	gl.DrawElementsInstanced(gl.PATCHES, 24, gl.UNSIGNED_INT, nil, int32(numSpheres))
+	gl.BindVertexArray(0)
+	gl.UseProgram(0)
+}
+
+
+func setProjection(projection mgl.Mat4) {
+	gl.ProgramUniformMatrix4fv(sphereProgram, sphereProgramProjection, 1, false, &projection[0])
+	gl.ProgramUniformMatrix4fv(axisProgram, axisProgramProjection, 1, false, &projection[0])
+}
+
+
+// moveCamera applies the camera input of the last dt seconds. Keys and mouse drags accelerate the orbit around the
+// watched point or, in free-fly mode, the camera itself, and all of that motion decays with cameraDamping. A recalled
+// bookmark or a followed target then pulls the camera over.
+func moveCamera(dt float32, target mgl.Vec3, following bool) {
+	up := mgl.Vec3{0, 1, 0}
+	damping := float32(math.Pow(cameraDamping, float64(dt)))
+	forward := camera.watch.Sub(camera.root).Normalize()
+	right := forward.Cross(up).Normalize()
+
+	left, rightward := leftKeyPressed || leftKeyOn, rightKeyPressed || rightKeyOn
+	upward, downward := upKeyPressed || upKeyOn, downKeyPressed || downKeyOn
+	leftKeyPressed, rightKeyPressed, upKeyPressed, downKeyPressed = false, false, false, false
+
+	if freeFly {
+		var thrust mgl.Vec3
+		if upward {
+			thrust = thrust.Add(forward)
+		}
+		if downward {
+			thrust = thrust.Sub(forward)
+		}
+		if rightward {
+			thrust = thrust.Add(right)
+		}
+		if left {
+			thrust = thrust.Sub(right)
+		}
+		thrust = thrust.Mul(cameraFlyAcceleration * dt).Sub(forward.Mul(scrollDirection * cameraFlyPerScroll))
+		cameraFlyVelocity = cameraFlyVelocity.Add(thrust).Mul(damping)
+		step := cameraFlyVelocity.Mul(dt)
+		camera.root = camera.root.Add(step)
+		camera.watch = camera.watch.Add(step)
+
+		// dragging the mouse turns the view around the camera
+		look := rotate(camera.watch.Sub(camera.root), up, -cursorDeltaX * cameraMouseSensitivity)
+		pitched := rotate(look, right, -cursorDeltaY * cameraMouseSensitivity)
+		if math.Abs(float64(pitched.Normalize().Dot(up))) < 0.99 {
+			look = pitched
+		}
+		camera.watch = camera.root.Add(look)
+	} else {
+		if left {
+			cameraYawVelocity -= cameraAngularAcceleration * dt
+		} else if rightward {
+			cameraYawVelocity += cameraAngularAcceleration * dt
+		}
+		if upward {
+			cameraPitchVelocity -= cameraAngularAcceleration * dt
+		} else if downward {
+			cameraPitchVelocity += cameraAngularAcceleration * dt
+		}
+
+		// a drag turns the orbit with the mouse and leaves it spinning at the speed of the drag
+		if dragging && dt > 0 {
+			cameraYawVelocity = -cursorDeltaX * cameraMouseSensitivity / dt
+			cameraPitchVelocity = -cursorDeltaY * cameraMouseSensitivity / dt
+		}
+		cameraZoomVelocity += scrollDirection * cameraZoomPerScroll
+
+		cameraYawVelocity *= damping
+		cameraPitchVelocity *= damping
+		cameraZoomVelocity *= damping
+
+		offset := rotate(camera.root.Sub(camera.watch), up, cameraYawVelocity * dt)
+		pitched := rotate(offset, up.Cross(offset).Normalize(), cameraPitchVelocity * dt)
+		if math.Abs(float64(pitched.Normalize().Dot(up))) < 0.99 {
+			offset = pitched
+		} else {
+			cameraPitchVelocity = 0
+		}
+		distance := offset.Len() * float32(math.Exp(float64(cameraZoomVelocity * dt)))
+		distance = mgl.Clamp(distance, cameraMinDistance, cameraMaxDistance)
+		camera.root = camera.watch.Add(offset.Normalize().Mul(distance))
+	}
+
+	scrollDirection = 0
+	scrolling = false
+	cursorDeltaX, cursorDeltaY = 0, 0
+
+	pull := 1 - float32(math.Pow(cameraFollowDamping, float64(dt)))
+	if cameraGoal != nil {
+		camera.root = camera.root.Add(cameraGoal.root.Sub(camera.root).Mul(pull))
+		camera.watch = camera.watch.Add(cameraGoal.watch.Sub(camera.watch).Mul(pull))
+		if camera.root.Sub(cameraGoal.root).Len() < 1 && camera.watch.Sub(cameraGoal.watch).Len() < 1 {
+			cameraGoal = nil
+		}
+	} else if following {
+		shift := target.Sub(camera.watch).Mul(pull)
+		camera.root = camera.root.Add(shift)
+		camera.watch = camera.watch.Add(shift)
+	}
+
+	view := mgl.LookAtV(camera.root, camera.watch, up)
+	gl.ProgramUniformMatrix4fv(sphereProgram, sphereProgramView, 1, false, &view[0])
+	gl.ProgramUniformMatrix4fv(axisProgram, axisProgramView, 1, false, &view[0])
+	gl.ProgramUniform3fv(sphereProgram, sphereProgramCameraLocation, 1, &camera.root[0])
+}
+
+
+func rotate(v mgl.Vec3, axis mgl.Vec3, angle float32) mgl.Vec3 {
+	return mgl.HomogRotate3D(angle, axis).Mul4x1(v.Vec4(0)).Vec3()
+}
+
+
+// followTarget is where the followed orb or the centre of mass of all orbs is rendered, false if neither is followed;
+// the centre of mass is the one of the last profiling reduction, which saves reading back all orbs every frame
+func followTarget(numSpheres int, locationBuffer1Active bool, interpolation float32) (mgl.Vec3, bool) {
+	if followCenterOfMass {
+		return centerOfMass, true
+	}
+	if followedOrb < 0 {
+		return mgl.Vec3{}, false
+	}
+	orb := followedOrb
+	if orb > numSpheres - 1 {
+		orb = numSpheres - 1
+	}
+
+	// the shaders render between the location at binding 0 and the one at binding 1
+	var from, to Location
+	locationBuffer0, locationBuffer1 := locationBuffers(locationBuffer1Active)
+	gl.MemoryBarrier(gl.BUFFER_UPDATE_BARRIER_BIT)
+	gl.GetNamedBufferSubData(locationBuffer0, orb * 4 * 4, 4 * 4, unsafe.Pointer(&from))
+	gl.GetNamedBufferSubData(locationBuffer1, orb * 4 * 4, 4 * 4, unsafe.Pointer(&to))
+
+	return from.location.Add(to.location.Sub(from.location).Mul(interpolation)), true
+}
+
+
+// camera bookmarks are kept in cameraBookmarksFileName as lines of slot, root and watch point
+func loadBookmarks() {
+	file, err := os.Open(cameraBookmarksFileName)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Fatalln("Could not open", cameraBookmarksFileName, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var slot int
+		var c Camera
+		_, err := fmt.Sscanf(
+			scanner.Text(),
+			"%d, %g, %g, %g, %g, %g, %g",
+			&slot,
+			&c.root[0], &c.root[1], &c.root[2],
+			&c.watch[0], &c.watch[1], &c.watch[2],
+		)
+		if err != nil {
+			log.Println("Skipping malformed line of", cameraBookmarksFileName, err)
+			continue
+		}
+		cameraBookmarks[slot] = c
+	}
+}
+
+
+func saveBookmark(slot int) {
+	cameraBookmarks[slot] = camera
+	file, err := os.Create(cameraBookmarksFileName)
+	if err != nil {
+		log.Fatalln("Could not create", cameraBookmarksFileName, err)
+	}
+	defer file.Close()
+
+	for s := 1; s <= 9; s++ {
+		if c, ok := cameraBookmarks[s]; ok {
+			_, err := fmt.Fprintf(file, "%d, %v, %v, %v, %v, %v, %v\n", s, c.root[0], c.root[1], c.root[2], c.watch[0], c.watch[1], c.watch[2])
+			if err != nil {
+				log.Fatalln("Could not write to", cameraBookmarksFileName, err)
+			}
+		}
+	}
+	fmt.Println("\nSaved camera bookmark", slot)
+}
+
+
+func recallBookmark(slot int) {
+	if c, ok := cameraBookmarks[slot]; ok {
+		cameraGoal = &c
+		followedOrb, followCenterOfMass = -1, false
+	}
+}
+
+
+// presentFrame moves the camera and renders the orbs interpolation of the way from their last to their current
+// locations into the window, then waits for the frame rate limit
+func presentFrame(window *glfw.Window, numSpheres int, locationBuffer1Active bool, interpolation float32) {
+	target, following := followTarget(numSpheres, locationBuffer1Active, interpolation)
+	moveCamera(float32(math.Min(seconds() - lastFrameTime, 0.1)), target, following)
+
+	gl.ProgramUniform1f(sphereProgram, sphereProgramInterpolation, interpolation)
+	renderScene(numSpheres)
+	window.SwapBuffers()
+
+	if *maxFramesPerSecond > 0 {
+		if wait := lastFrameTime + 1 / *maxFramesPerSecond - seconds(); wait > 0 {
+			time.Sleep(time.Duration(wait * float64(time.Second)))
+		}
 	}
-	file.Close()
+	lastFrameTime = seconds()
+}
+
+
+// realTimeLead is how many days the simulation after step steps is ahead of the wall clock since start, 0 without a
+// real-time rate
+func realTimeLead(step int, start float64) float64 {
+	if *daysPerSecond <= 0 {
+		return 0
+	}
+	return float64(step) * deltaT - (seconds() - start) * *daysPerSecond
+}
+
+
+// changeSpeed doubles or halves the real-time rate, or without one the number of steps per frame
+func changeSpeed(faster bool) {
+	factor := 0.5
+	if faster {
+		factor = 2
+	}
+	if *daysPerSecond > 0 {
+		*daysPerSecond *= factor
+		fmt.Printf("\n%v simulated days per second\n", *daysPerSecond)
+	} else {
+		*substeps = int(math.Max(1, float64(*substeps) * factor))
+		fmt.Printf("\n%v steps per frame\n", *substeps)
+	}
+}
+
+
+// realTimeStartAt is when a run that already took step steps would have started at the current real-time rate, so
+// that pauses and speed changes keep the simulation on the wall clock
+func realTimeStartAt(step int) float64 {
+	if *daysPerSecond <= 0 {
+		return seconds()
+	}
+	return seconds() - float64(step) * deltaT / *daysPerSecond
+}
+
+
+// windowClosed reports whether the user closed the window, a headless run has none to close
+func windowClosed(window *glfw.Window) bool {
+	return window != nil && window.ShouldClose()
+}
+
+
+// seconds since the start of the program, replaces GLFW's timer that needs an initialized GLFW
+func seconds() float64 {
+	return time.Since(startTime).Seconds()
 }
 
 
@@ -840,6 +1732,260 @@ func init() {
 }
 
 
+// defines and force law functions shared by all compute shaders, derived from the simulation constants above
+func computeShaderPreamble() string {
+	var perParticle, charged, comovingIntegration, postNewtonianCorrections int
+	if perParticleSoftening {
+		perParticle = 1
+	}
+	if forceLaw.Charged() {
+		charged = 1
+	}
+	if comoving {
+		comovingIntegration = 1
+	}
+	if postNewtonian.Enabled() {
+		postNewtonianCorrections = 1
+	}
+
+	return fmt.Sprintf(
+		"#define DELTA_T %e\n#define SOFTENING_KERNEL %v\n#define SOFTEN %e\n#define PER_PARTICLE_SOFTENING %v\n" +
+			"#define NUM_EXTERNAL_POTENTIALS %v\n#define NUM_MASSIVE_ORBS %v\n#define CHARGED %v\n" +
+			"#define COMOVING %v\n#define BACKGROUND_ACCELERATION %e\n#define POST_NEWTONIAN %v\n%v%v",
+		deltaT,
+		softeningKernel,
+		softeningLength,
+		perParticle,
+		len(externalPotentials),
+		numMassiveOrbs,
+		charged,
+		comovingIntegration,
+		cosmology.BackgroundAcceleration(),
+		postNewtonianCorrections,
+		forceLaw.GLSL(),
+		postNewtonian.GLSL(),
+	)
+}
+
+
+func newPointMassPotential(mass float32) ExternalPotential {
+	return ExternalPotential{pointMassPotential, [3]float32{mass, 0, 0}}
+}
+
+
+func newPlummerPotential(mass, radius float32) ExternalPotential {
+	return ExternalPotential{plummerPotential, [3]float32{mass, radius, 0}}
+}
+
+
+func newHernquistPotential(mass, radius float32) ExternalPotential {
+	return ExternalPotential{hernquistPotential, [3]float32{mass, radius, 0}}
+}
+
+
+// the mass of an NFW halo is its characteristic mass 4 * pi * rho_0 * r_s^3, the radius its scale radius r_s
+func newNFWPotential(mass, radius float32) ExternalPotential {
+	return ExternalPotential{nfwPotential, [3]float32{mass, radius, 0}}
+}
+
+
+func newMiyamotoNagaiPotential(mass, radius, height float32) ExternalPotential {
+	return ExternalPotential{miyamotoNagaiPotential, [3]float32{mass, radius, height}}
+}
+
+
+// velocity is the asymptotic circular velocity, flattening the axis ratio of the equipotential surfaces
+func newLogarithmicPotential(velocity, coreRadius, flattening float32) ExternalPotential {
+	return ExternalPotential{logarithmicPotential, [3]float32{velocity, coreRadius, flattening}}
+}
+
+
+// quadrupole bar of Dehnen (2000) rotating about the y axis, the amplitude is given in units of the potential
+func newBarPotential(amplitude, radius, patternSpeed float32) ExternalPotential {
+	return ExternalPotential{barPotential, [3]float32{amplitude, radius, patternSpeed}}
+}
+
+
+// mirrors external_acceleration in the compute shaders, so that the initial velocities can account for the
+// external potentials
+func externalAcceleration(location mgl.Vec3, time float32) mgl.Vec3 {
+	sqrt := func(x float32) float32 {
+		return float32(math.Sqrt(float64(x)))
+	}
+
+	var sum mgl.Vec3
+
+	x, y, z := location.X(), location.Y(), location.Z()
+	r2 := location.Dot(location)
+	if r2 == 0 {
+		return sum
+	}
+	r := sqrt(r2)
+	cylindricalR2 := x * x + z * z
+
+	for _, potential := range externalPotentials {
+		p0, p1, p2 := potential.parameters[0], potential.parameters[1], potential.parameters[2]
+		switch potential.kind {
+		case pointMassPotential:
+			sum = sum.Sub(location.Mul(G * p0 / (r2 * r)))
+		case plummerPotential:
+			brackets := r2 + p1 * p1
+			sum = sum.Sub(location.Mul(G * p0 / sqrt(brackets * brackets * brackets)))
+		case hernquistPotential:
+			sum = sum.Sub(location.Mul(G * p0 / (r * (r + p1) * (r + p1))))
+		case nfwPotential:
+			sum = sum.Sub(location.Mul(G * p0 * (float32(math.Log1p(float64(r / p1))) - r / (r + p1)) / (r2 * r)))
+		case miyamotoNagaiPotential:
+			s := sqrt(y * y + p2 * p2)
+			brackets := cylindricalR2 + (p1 + s) * (p1 + s)
+			sum = sum.Sub(mgl.Vec3{x, y * (p1 + s) / s, z}.Mul(G * p0 / sqrt(brackets * brackets * brackets)))
+		case logarithmicPotential:
+			brackets := p1 * p1 + cylindricalR2 + y * y / (p2 * p2)
+			sum = sum.Sub(mgl.Vec3{x, y / (p2 * p2), z}.Mul(p0 * p0 / brackets))
+		case barPotential:
+			if cylindricalR2 == 0 {
+				break
+			}
+			cylindricalR := sqrt(cylindricalR2)
+			angle := 2 * (float32(math.Atan2(float64(z), float64(x))) - p2 * time)
+			u := cylindricalR / p1
+			var radialProfile, radialProfileDerivative float32
+			if u < 1 {
+				radialProfile = u * u * u - 2
+				radialProfileDerivative = 3 * u * u / p1
+			} else {
+				radialProfile = -1 / (u * u * u)
+				radialProfileDerivative = 3 / (p1 * u * u * u * u)
+			}
+			radial := -p0 * float32(math.Cos(float64(angle))) * radialProfileDerivative
+			azimuthal := 2 * p0 * float32(math.Sin(float64(angle))) * radialProfile / cylindricalR
+			sum = sum.Add(mgl.Vec3{x, 0, z}.Mul(radial / cylindricalR)).Add(mgl.Vec3{-z, 0, x}.Mul(azimuthal / cylindricalR))
+		}
+	}
+
+	return sum
+}
+
+
+func getStepFactorUniforms(program uint32) StepFactorUniforms {
+	return StepFactorUniforms{
+		gl.GetUniformLocation(program, gl.Str("drift_factor\x00")),
+		gl.GetUniformLocation(program, gl.Str("kick_factor\x00")),
+		gl.GetUniformLocation(program, gl.Str("last_drift_factor\x00")),
+	}
+}
+
+
+func (u StepFactorUniforms) set(program uint32, drift, kick, lastDrift float32) {
+	gl.ProgramUniform1f(program, u.drift, drift)
+	gl.ProgramUniform1f(program, u.kick, kick)
+	gl.ProgramUniform1f(program, u.lastDrift, lastDrift)
+}
+
+
+func snapshotScaleFactors() []float64 {
+	scaleFactors := make([]float64, len(snapshotRedshifts))
+	for i, z := range snapshotRedshifts {
+		scaleFactors[i] = nbody.ScaleFactor(z)
+	}
+	return scaleFactors
+}
+
+
+// orbs uniformly filling a sphere of comovingRadius at the background density, at rest in comoving coordinates,
+// i.e. moving with the Hubble flow
+func newComovingSphere(numSpheres int) ([]Location, []Velocity) {
+	numMassive := numSpheres
+	if numMassiveOrbs > 0 && numMassiveOrbs < numSpheres {
+		numMassive = numMassiveOrbs
+	}
+	orbMass := float32(cosmology.BackgroundMass(G, comovingRadius) / float64(numMassive))
+
+	var orbLocations []Location = make([]Location, numSpheres)
+	for i := range orbLocations {
+		for {
+			location := mgl.Vec3{
+				rand.Float32() * 2 - 1,
+				rand.Float32() * 2 - 1,
+				rand.Float32() * 2 - 1,
+			}
+			if location.Len() <= 1 {
+				orbLocations[i].location = location.Mul(comovingRadius)
+				break
+			}
+		}
+		if i < numMassive {
+			orbLocations[i].mass = orbMass
+		}
+	}
+
+	return orbLocations, make([]Velocity, numSpheres)
+}
+
+
+// the buffers bound to the location bindings 0 and 1, i.e. the current and the previous locations once a frame is done
+func locationBuffers(locationBuffer1Active bool) (uint32, uint32) {
+	if locationBuffer1Active {
+		return gravityLocationBuffer1, gravityLocationBuffer0
+	}
+	return gravityLocationBuffer0, gravityLocationBuffer1
+}
+
+
+// bindLocationBuffers binds the current locations to binding 0 and the last ones to binding 1
+func bindLocationBuffers(locationBuffer1Active bool) {
+	locationBuffer, lastLocationBuffer := locationBuffers(locationBuffer1Active)
+	gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 0, locationBuffer)
+	gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 1, lastLocationBuffer)
+}
+
+
+func toVec3(v mgl.Vec3) nbody.Vec3 {
+	return nbody.Vec3{float64(v[0]), float64(v[1]), float64(v[2])}
+}
+
+
+// read the orbs back from the given location buffers, the velocity buffer only holds the initial velocities, hence
+// the velocities are estimated from the last step, without the half kick the profiling compute shader adds
+func readOrbs(numSpheres int, locationBuffer, lastLocationBuffer uint32, lastDrift float32) []nbody.Body {
+	var orbLocations []Location = make([]Location, numSpheres)
+	var lastOrbLocations []Location = make([]Location, numSpheres)
+	gl.MemoryBarrier(gl.BUFFER_UPDATE_BARRIER_BIT)
+	gl.GetNamedBufferSubData(locationBuffer, 0, numSpheres * 4 * 4, unsafe.Pointer(&orbLocations[0]))
+	gl.GetNamedBufferSubData(lastLocationBuffer, 0, numSpheres * 4 * 4, unsafe.Pointer(&lastOrbLocations[0]))
+
+	orbs := make([]nbody.Body, numSpheres)
+	for i := range orbs {
+		orbs[i].Position = toVec3(orbLocations[i].location)
+		orbs[i].Velocity = toVec3(orbLocations[i].location.Sub(lastOrbLocations[i].location).Mul(1 / lastDrift))
+		orbs[i].Mass = float64(orbLocations[i].mass)
+	}
+	return orbs
+}
+
+
+// write the orbs of a comoving run at the given scale factor next to the profiling measurements
+func writeComovingSnapshot(label string, orbs []nbody.Body, scaleFactor float64) {
+	redshift := nbody.Redshift(scaleFactor)
+	fileName := fmt.Sprintf("%s-%s-z%.3g.csv", strings.TrimSuffix(profilingFileName, ".csv"), label, redshift)
+	err := nbody.WriteSnapshot(
+		fileName,
+		orbs,
+		map[string]string{
+			"redshift": fmt.Sprint(redshift),
+			"scale_factor": fmt.Sprint(scaleFactor),
+			"omega_matter": fmt.Sprint(cosmology.OmegaMatter),
+			"omega_lambda": fmt.Sprint(cosmology.OmegaLambda),
+			"h0": fmt.Sprint(cosmology.H0),
+			"coordinates": "comoving positions, canonical momenta per mass a^2 dx/dt",
+		},
+	)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+
 func newShader(fileName string, shaderType uint32) (uint32, error) {
 	file, err := os.Open(fileName)
 	if err != nil {
@@ -894,7 +2040,7 @@ func newShader(fileName string, shaderType uint32) (uint32, error) {
 }
 
 
-func newComputeShader(fileName string, localWorkGroupSize, numSpheres, numTiles uint32) (uint32, error) {
+func newComputeShader(fileName string, localWorkGroupSize, numSpheres, numTiles uint32, defines string) (uint32, error) {
 	file, err := os.Open(fileName)
 	if err != nil {
 		return 0, fmt.Errorf("Could not open '%s': %s", fileName, err)
@@ -914,6 +2060,7 @@ func newComputeShader(fileName string, localWorkGroupSize, numSpheres, numTiles
 
 	source := string(bSource) + "\x00"
 	source = fmt.Sprintf(source, localWorkGroupSize, numSpheres, numTiles)
+	source = strings.Replace(source, "#version 450\n", "#version 450\n" + defines, 1)
 
 	shader := gl.CreateShader(gl.COMPUTE_SHADER)
 	if shader == 0 {
@@ -0,0 +1,7 @@
+
+// Package headless provides OpenGL contexts without any window, so that simulations and benchmarks can run on
+// machines without a display, e.g. with Mesa's llvmpipe on batch machines and in CI.
+//
+// The contexts come from EGL's surfaceless platform. Programs have to be built with '-tags egl', which also makes
+// go-gl/gl load its functions through EGL instead of GLX.
+package headless
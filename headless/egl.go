@@ -0,0 +1,84 @@
+
+// +build egl
+
+package headless
+
+
+/*
+#cgo LDFLAGS: -lEGL
+
+#include <EGL/egl.h>
+#include <EGL/eglext.h>
+
+// creates an OpenGL core context on Mesa's surfaceless platform and makes it current without any surface, returns
+// EGL_SUCCESS or the error of the first failed call
+static EGLint createContext(EGLint major, EGLint minor, EGLDisplay *display, EGLContext *context) {
+	PFNEGLGETPLATFORMDISPLAYEXTPROC getPlatformDisplay =
+		(PFNEGLGETPLATFORMDISPLAYEXTPROC) eglGetProcAddress("eglGetPlatformDisplayEXT");
+	if (getPlatformDisplay == NULL) {
+		return EGL_BAD_DISPLAY;
+	}
+
+	*display = getPlatformDisplay(EGL_PLATFORM_SURFACELESS_MESA, EGL_DEFAULT_DISPLAY, NULL);
+	if (*display == EGL_NO_DISPLAY) {
+		return EGL_BAD_DISPLAY;
+	}
+	if (!eglInitialize(*display, NULL, NULL) || !eglBindAPI(EGL_OPENGL_API)) {
+		return eglGetError();
+	}
+
+	EGLint attributes[] = {
+		EGL_CONTEXT_MAJOR_VERSION, major,
+		EGL_CONTEXT_MINOR_VERSION, minor,
+		EGL_CONTEXT_OPENGL_PROFILE_MASK, EGL_CONTEXT_OPENGL_CORE_PROFILE_BIT,
+		EGL_NONE,
+	};
+	*context = eglCreateContext(*display, EGL_NO_CONFIG_KHR, EGL_NO_CONTEXT, attributes);
+	if (*context == EGL_NO_CONTEXT) {
+		EGLint err = eglGetError();
+		eglTerminate(*display);
+		return err;
+	}
+	if (!eglMakeCurrent(*display, EGL_NO_SURFACE, EGL_NO_SURFACE, *context)) {
+		EGLint err = eglGetError();
+		eglDestroyContext(*display, *context);
+		eglTerminate(*display);
+		return err;
+	}
+	return EGL_SUCCESS;
+}
+
+static void destroyContext(EGLDisplay display, EGLContext context) {
+	eglMakeCurrent(display, EGL_NO_SURFACE, EGL_NO_SURFACE, EGL_NO_CONTEXT);
+	eglDestroyContext(display, context);
+	eglTerminate(display);
+}
+*/
+import "C"
+
+
+import (
+	"fmt"
+)
+
+
+type Context struct {
+	display C.EGLDisplay
+	context C.EGLContext
+}
+
+
+// NewContext creates an OpenGL core context of the given version without any window or surface and makes it current
+// on the calling thread, which has to stay locked to it. Rendering needs a framebuffer object of its own.
+func NewContext(major, minor int) (*Context, error) {
+	var c Context
+	if err := C.createContext(C.EGLint(major), C.EGLint(minor), &c.display, &c.context); err != C.EGL_SUCCESS {
+		return nil, fmt.Errorf("Could not create a surfaceless EGL context for OpenGL %v.%v: EGL error 0x%x", major, minor, int(err))
+	}
+	return &c, nil
+}
+
+
+func (c *Context) Destroy() {
+	C.destroyContext(c.display, c.context)
+}
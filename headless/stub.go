@@ -0,0 +1,21 @@
+
+// +build !egl
+
+package headless
+
+
+import (
+	"errors"
+)
+
+
+type Context struct{}
+
+
+// NewContext fails without the egl build tag, go-gl/gl has to load its functions through EGL for headless runs.
+func NewContext(major, minor int) (*Context, error) {
+	return nil, errors.New("Headless mode needs a build with '-tags egl'")
+}
+
+
+func (c *Context) Destroy() {}
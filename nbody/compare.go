@@ -0,0 +1,62 @@
+
+package nbody
+
+
+import (
+	"math"
+	"sort"
+)
+
+
+// Deviations describes the distribution of the per-body distances between two sets of vectors.
+type Deviations struct {
+	Values []float64		// by body
+	Max, RMS float64
+	P50, P90, P99 float64		// percentiles
+}
+
+
+func newDeviations(values []float64) Deviations {
+	d := Deviations{Values: values}
+	for _, value := range values {
+		d.Max = math.Max(d.Max, value)
+		d.RMS += value * value
+	}
+	d.RMS = math.Sqrt(d.RMS / float64(len(values)))
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	d.P50 = percentile(sorted, 50)
+	d.P90 = percentile(sorted, 90)
+	d.P99 = percentile(sorted, 99)
+	return d
+}
+
+
+// percentile interpolates linearly between the closest ranks of sorted.
+func percentile(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted) - 1)
+	lower := int(math.Floor(rank))
+	if lower + 1 >= len(sorted) {
+		return sorted[len(sorted) - 1]
+	}
+	return sorted[lower] + (rank - float64(lower)) * (sorted[lower + 1] - sorted[lower])
+}
+
+
+// Comparison holds the position and velocity deviations of two states of the same bodies, such as the GPU and the
+// CPU integration of the same initial conditions.
+type Comparison struct {
+	Position, Velocity Deviations
+}
+
+
+func Compare(a, b []Body) Comparison {
+	positions := make([]float64, len(a))
+	velocities := make([]float64, len(a))
+	for i := range a {
+		positions[i] = a[i].Position.Sub(b[i].Position).Len()
+		velocities[i] = a[i].Velocity.Sub(b[i].Velocity).Len()
+	}
+	return Comparison{newDeviations(positions), newDeviations(velocities)}
+}
@@ -0,0 +1,90 @@
+
+package nbody
+
+
+import (
+	"math"
+)
+
+
+// ConvergenceLevel is a single run of a convergence measurement.
+type ConvergenceLevel struct {
+	StepSize float64
+	Steps int
+	Error float64		// RMS deviation of the final positions from the reference
+}
+
+
+// Convergence is the outcome of integrating the same initial conditions over the same time with successively halved
+// step sizes. Order is the slope of the least squares fit of log(error) over log(step size), the empirical global
+// order of the integrator.
+type Convergence struct {
+	Integrator string
+	Levels []ConvergenceLevel
+	Order float64
+}
+
+
+// MeasureConvergence integrates initial over duration with steps, 2 steps, 4 steps and so on, numLevels runs in
+// total, each with a fresh integrator from newIntegrator. The final positions are compared with reference, the final
+// bodies of an analytic solution; without one an additional run with half the smallest step size serves as the
+// reference.
+func MeasureConvergence(initial *System, newIntegrator func() Integrator, duration float64, steps, numLevels int, reference []Body) Convergence {
+	run := func(steps int) []Body {
+		s := initial.Clone()
+		integrator := newIntegrator()
+		dt := duration / float64(steps)
+		for i := 0; i < steps; i++ {
+			integrator.Step(s, dt)
+		}
+		return s.Bodies
+	}
+
+	if reference == nil {
+		reference = run(steps << uint(numLevels))
+	}
+
+	c := Convergence{Integrator: newIntegrator().Name()}
+	for level := 0; level < numLevels; level++ {
+		levelSteps := steps << uint(level)
+		c.Levels = append(c.Levels, ConvergenceLevel{
+			StepSize: duration / float64(levelSteps),
+			Steps: levelSteps,
+			Error: PositionError(run(levelSteps), reference),
+		})
+	}
+	c.Order = fitOrder(c.Levels)
+	return c
+}
+
+
+// PositionError is the RMS distance between the positions of the bodies in a and b.
+func PositionError(a, b []Body) float64 {
+	var sum float64
+	for i := range a {
+		d := a[i].Position.Sub(b[i].Position)
+		sum += d.Dot(d)
+	}
+	return math.Sqrt(sum / float64(len(a)))
+}
+
+
+// fitOrder skips levels without error, which happen once a run reproduces the reference to the last bit.
+func fitOrder(levels []ConvergenceLevel) float64 {
+	var n, sumX, sumY, sumXX, sumXY float64
+	for _, level := range levels {
+		if level.Error <= 0 || math.IsInf(level.Error, 0) || math.IsNaN(level.Error) {
+			continue
+		}
+		x, y := math.Log(level.StepSize), math.Log(level.Error)
+		n += 1
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+	}
+	if n < 2 {
+		return math.NaN()
+	}
+	return (n * sumXY - sumX * sumY) / (n * sumXX - sumX * sumX)
+}
@@ -0,0 +1,126 @@
+
+package nbody
+
+
+import (
+	"math"
+	"sort"
+)
+
+
+// Cosmology is a Friedmann background with matter, a cosmological constant and the curvature making up the rest.
+// H0 is in units of 1/day, like all other times of the simulation.
+//
+// Comoving integration follows the canonical formulation of GADGET-2 (Springel 2005): positions x are comoving and the
+// velocities of the bodies hold the canonical momenta per mass p = a^2 dx/dt. A step from a0 to a1 then drifts
+// x by p times DriftFactor(a0, a1) and kicks p by the comoving acceleration times KickFactor(a0, a1), so that every
+// integrator written in terms of drift and kick carries over unchanged.
+type Cosmology struct {
+	OmegaMatter, OmegaLambda, H0 float64
+}
+
+
+// numFactorIntervals is the number of Simpson intervals in ln(a) used for the time integrals.
+const numFactorIntervals = 64
+
+
+// Hubble returns the Hubble rate H(a).
+func (c Cosmology) Hubble(a float64) float64 {
+	omegaCurvature := 1 - c.OmegaMatter - c.OmegaLambda
+	return c.H0 * math.Sqrt(c.OmegaMatter / (a * a * a) + omegaCurvature / (a * a) + c.OmegaLambda)
+}
+
+
+// integrate returns the integral of f(a) dt from a0 to a1, with dt = da / (a H(a)) = d ln(a) / H(a).
+func (c Cosmology) integrate(a0, a1 float64, f func(a float64) float64) float64 {
+	x0, x1 := math.Log(a0), math.Log(a1)
+	h := (x1 - x0) / numFactorIntervals
+	integrand := func(x float64) float64 {
+		a := math.Exp(x)
+		return f(a) / c.Hubble(a)
+	}
+
+	sum := integrand(x0) + integrand(x1)
+	for i := 1; i < numFactorIntervals; i++ {
+		weight := 2.0
+		if i % 2 == 1 {
+			weight = 4.0
+		}
+		sum += weight * integrand(x0 + float64(i) * h)
+	}
+	return sum * h / 3
+}
+
+
+// CosmicTime returns the time elapsing between the scale factors a0 and a1.
+func (c Cosmology) CosmicTime(a0, a1 float64) float64 {
+	return c.integrate(a0, a1, func(a float64) float64 { return 1 })
+}
+
+
+// DriftFactor returns the integral of dt / a^2 from a0 to a1.
+func (c Cosmology) DriftFactor(a0, a1 float64) float64 {
+	return c.integrate(a0, a1, func(a float64) float64 { return 1 / (a * a) })
+}
+
+
+// KickFactor returns the integral of dt / a from a0 to a1.
+func (c Cosmology) KickFactor(a0, a1 float64) float64 {
+	return c.integrate(a0, a1, func(a float64) float64 { return 1 / a })
+}
+
+
+// BackgroundAcceleration is the factor of the comoving position in the acceleration that a vacuum bounded sphere of
+// background density needs on top of the pairwise forces, the cosmological constant does not appear since it only
+// acts through the expansion.
+func (c Cosmology) BackgroundAcceleration() float64 {
+	return 0.5 * c.OmegaMatter * c.H0 * c.H0
+}
+
+
+// BackgroundMass returns the mass of a comoving sphere of the given radius at the mean matter density.
+func (c Cosmology) BackgroundMass(G, radius float64) float64 {
+	return c.OmegaMatter * c.H0 * c.H0 * radius * radius * radius / (2 * G)
+}
+
+
+func ScaleFactor(redshift float64) float64 {
+	return 1 / (1 + redshift)
+}
+
+
+func Redshift(scaleFactor float64) float64 {
+	return 1 / scaleFactor - 1
+}
+
+
+// ScaleFactorSchedule returns the numSteps + 1 scale factors of steps from aStart to aEnd, evenly spaced in ln(a).
+// Every snapshot scale factor within (aStart, aEnd] replaces the grid point nearest to it, so that snapshots fall on
+// a step exactly; their step indices are returned in order of increasing scale factor. Snapshots less than a step
+// apart from an earlier one are dropped.
+func ScaleFactorSchedule(aStart, aEnd float64, numSteps int, snapshots []float64) ([]float64, []int) {
+	scaleFactors := make([]float64, numSteps + 1)
+	h := math.Log(aEnd / aStart) / float64(numSteps)
+	for i := range scaleFactors {
+		scaleFactors[i] = aStart * math.Exp(float64(i) * h)
+	}
+	scaleFactors[numSteps] = aEnd
+
+	sorted := append([]float64(nil), snapshots...)
+	sort.Float64s(sorted)
+
+	var snapshotSteps []int
+	for _, a := range sorted {
+		if a <= aStart || a > aEnd {
+			continue
+		}
+		step := int(math.Round(math.Log(a / aStart) / h))
+		step = max(1, min(step, numSteps))
+		if len(snapshotSteps) > 0 && step <= snapshotSteps[len(snapshotSteps) - 1] {
+			continue
+		}
+		scaleFactors[step] = a
+		snapshotSteps = append(snapshotSteps, step)
+	}
+	return scaleFactors, snapshotSteps
+}
@@ -0,0 +1,73 @@
+
+package nbody
+
+
+import (
+	"math"
+)
+
+
+// Elements are the osculating Keplerian elements of an orbit around a central body, the inclination being measured
+// against a reference plane given by its normal. Unbound orbits have a negative semi-major axis, parabolic ones an
+// infinite one.
+type Elements struct {
+	SemiMajorAxis float64
+	Eccentricity float64
+	Inclination float64		// in radians, between 0 and pi
+	Periapsis, Apoapsis float64		// the apoapsis of unbound orbits is infinite
+	Energy float64		// per reduced mass
+	Bound bool
+}
+
+
+// NewElements returns the elements of the relative position r and velocity v of two bodies, with mu being G times
+// the sum of their masses.
+func NewElements(r, v Vec3, mu float64, normal Vec3) Elements {
+	distance := r.Len()
+	h := r.Cross(v)
+
+	var e Elements
+	e.Energy = 0.5 * v.Dot(v) - mu / distance
+	e.Bound = e.Energy < 0
+	e.SemiMajorAxis = -mu / (2 * e.Energy)
+	e.Eccentricity = v.Cross(h).Mul(1 / mu).Sub(r.Mul(1 / distance)).Len()
+	if hLength := h.Len(); hLength > 0 {
+		e.Inclination = math.Acos(math.Max(-1, math.Min(1, h.Dot(normal) / (hLength * normal.Len()))))
+	}
+
+	e.Periapsis = h.Dot(h) / (mu * (1 + e.Eccentricity))
+	e.Apoapsis = math.Inf(1)
+	if e.Bound {
+		e.Apoapsis = e.SemiMajorAxis * (1 + e.Eccentricity)
+	}
+	return e
+}
+
+
+// OrbitalElements returns the elements of every body relative to the body at index 0, whose own elements are left
+// zero. The reference plane is the plane perpendicular to normal.
+func OrbitalElements(bodies []Body, G float64, normal Vec3) []Elements {
+	elements := make([]Elements, len(bodies))
+	central := bodies[0]
+	for i := 1; i < len(bodies); i++ {
+		b := bodies[i]
+		elements[i] = NewElements(
+			b.Position.Sub(central.Position),
+			b.Velocity.Sub(central.Velocity),
+			G * (central.Mass + b.Mass),
+			normal,
+		)
+	}
+	return elements
+}
+
+
+// OrbitalAngularMomentum is the sum of the specific angular momenta of all bodies relative to the body at index 0,
+// the normal of the plane that a disk around it lies in. Being specific, massless tracers count as well.
+func OrbitalAngularMomentum(bodies []Body) Vec3 {
+	var l Vec3
+	for _, b := range bodies[1:] {
+		l = l.Add(b.Position.Sub(bodies[0].Position).Cross(b.Velocity.Sub(bodies[0].Velocity)))
+	}
+	return l
+}
@@ -0,0 +1,168 @@
+
+package nbody
+
+
+import (
+	"math"
+	"sort"
+)
+
+
+// kinds of encounter events
+const (
+	EncounterEvent = "encounter"
+	BinaryFormedEvent = "binary_formed"
+	BinaryDissolvedEvent = "binary_dissolved"
+	BinaryEvent = "binary"		// still bound at the end of a run
+)
+
+
+// Event is a close encounter of, or a persistent binary formed or dissolved by, the bodies I and J. Pericentre,
+// RelativeVelocity and the Elements are those of their two-body orbit at the closest sampled approach of an
+// encounter, and at the time of the event for binaries.
+type Event struct {
+	Kind string
+	Time float64
+	I, J int
+	Pericentre, RelativeVelocity float64
+	Elements Elements
+}
+
+
+// pair state of the EncounterDetector
+type encounter struct {
+	closest float64
+	event Event
+}
+
+type binary struct {
+	since float64
+	reported bool
+}
+
+
+// EncounterDetector follows the close pairs of a system from one state to the next. Pairs closer than Distance are
+// in an encounter, which is logged once they separate again. Pairs closer than BinaryDistance with a negative
+// two-body energy are binary candidates, reported as binaries once they stayed bound for MinBinaryTime. Pairs with
+// a massless body never count, nor pairs with the central orb at index 0 if SkipCentral is set.
+type EncounterDetector struct {
+	G float64
+	Distance, BinaryDistance float64
+	MinBinaryTime float64
+	SkipCentral bool
+
+	encounters map[[2]int]*encounter
+	binaries map[[2]int]*binary
+}
+
+
+func (d *EncounterDetector) twoBody(bodies []Body, i, j int) (distance float64, relativeVelocity float64, elements Elements) {
+	r := bodies[j].Position.Sub(bodies[i].Position)
+	v := bodies[j].Velocity.Sub(bodies[i].Velocity)
+	return r.Len(), v.Len(), NewElements(r, v, d.G * (bodies[i].Mass + bodies[j].Mass), Vec3{0, 1, 0})
+}
+
+
+// Update takes the state of the bodies at time and returns the events that it completes, in order of time.
+func (d *EncounterDetector) Update(bodies []Body, time float64) []Event {
+	if d.encounters == nil {
+		d.encounters = map[[2]int]*encounter{}
+		d.binaries = map[[2]int]*binary{}
+	}
+
+	var events []Event
+	close := map[[2]int]bool{}
+	candidates := map[[2]int]bool{}
+	forEachPair(bodies, math.Max(d.Distance, d.BinaryDistance), func(i, j int) {
+		if bodies[i].Mass == 0 || bodies[j].Mass == 0 || (d.SkipCentral && i == 0) {
+			return
+		}
+		pair := [2]int{i, j}
+		distance, relativeVelocity, elements := d.twoBody(bodies, i, j)
+
+		if distance < d.Distance {
+			close[pair] = true
+			e, ok := d.encounters[pair]
+			if !ok {
+				e = &encounter{closest: math.Inf(1)}
+				d.encounters[pair] = e
+			}
+			if distance < e.closest {
+				e.closest = distance
+				e.event = Event{
+					Kind: EncounterEvent,
+					Time: time,
+					I: i,
+					J: j,
+					Pericentre: elements.Periapsis,
+					RelativeVelocity: relativeVelocity,
+					Elements: elements,
+				}
+			}
+		}
+
+		if distance < d.BinaryDistance && elements.Bound {
+			candidates[pair] = true
+			b, ok := d.binaries[pair]
+			if !ok {
+				b = &binary{since: time}
+				d.binaries[pair] = b
+			}
+			if !b.reported && time - b.since >= d.MinBinaryTime {
+				b.reported = true
+				events = append(events, Event{BinaryFormedEvent, time, i, j, elements.Periapsis, relativeVelocity, elements})
+			}
+		}
+	})
+
+	// encounters end once the pair separates, binaries once it is unbound or drifted apart
+	for pair, e := range d.encounters {
+		if !close[pair] {
+			events = append(events, e.event)
+			delete(d.encounters, pair)
+		}
+	}
+	for pair, b := range d.binaries {
+		if candidates[pair] {
+			continue
+		}
+		if b.reported {
+			_, relativeVelocity, elements := d.twoBody(bodies, pair[0], pair[1])
+			events = append(events, Event{BinaryDissolvedEvent, time, pair[0], pair[1], elements.Periapsis, relativeVelocity, elements})
+		}
+		delete(d.binaries, pair)
+	}
+	sortEvents(events)
+	return events
+}
+
+
+// Finish returns the encounters still going on, and the binaries still bound, in the final state of the bodies.
+func (d *EncounterDetector) Finish(bodies []Body, time float64) []Event {
+	var events []Event
+	for _, e := range d.encounters {
+		events = append(events, e.event)
+	}
+	for pair, b := range d.binaries {
+		if b.reported {
+			_, relativeVelocity, elements := d.twoBody(bodies, pair[0], pair[1])
+			events = append(events, Event{BinaryEvent, time, pair[0], pair[1], elements.Periapsis, relativeVelocity, elements})
+		}
+	}
+	d.encounters, d.binaries = nil, nil
+	sortEvents(events)
+	return events
+}
+
+
+func sortEvents(events []Event) {
+	sort.Slice(events, func(a, b int) bool {
+		if events[a].Time != events[b].Time {
+			return events[a].Time < events[b].Time
+		}
+		if events[a].I != events[b].I {
+			return events[a].I < events[b].I
+		}
+		return events[a].J < events[b].J
+	})
+}
@@ -0,0 +1,107 @@
+
+package nbody
+
+
+import (
+	"math"
+	"testing"
+)
+
+
+// separation and relative speed of a pair at one time, the speed is perpendicular to the separation
+type pairState struct {
+	separation, speed float64
+}
+
+
+func pairBodies(state pairState, mass float64) []Body {
+	return []Body{
+		{Position: Vec3{0, 0, 0}, Mass: 1},
+		{Position: Vec3{state.separation, 0, 0}, Velocity: Vec3{0, 0, state.speed}, Mass: mass},
+	}
+}
+
+
+func TestEncounterDetector(t *testing.T) {
+	// with unit masses and G = 1 a speed of 0.1 is bound at a separation of 50, a speed of 10 is not bound anywhere
+	cases := []struct {
+		name string
+		mass float64
+		states []pairState		// at the times 0, 1, 2, ...
+		want []Event		// only Kind and Time are compared
+	}{
+		{
+			"fly-by",
+			1,
+			[]pairState{{50, 10}, {5, 10}, {2, 10}, {8, 10}, {50, 10}},
+			[]Event{{Kind: EncounterEvent, Time: 2}},
+		},
+		{
+			"encounter going on at the end",
+			1,
+			[]pairState{{50, 10}, {5, 10}, {3, 10}},
+			[]Event{{Kind: EncounterEvent, Time: 2}},
+		},
+		{
+			"binary",
+			1,
+			[]pairState{{50, 0.1}, {50, 0.1}, {50, 0.1}, {50, 0.1}},
+			[]Event{{Kind: BinaryFormedEvent, Time: 2}, {Kind: BinaryEvent, Time: 3}},
+		},
+		{
+			"binary candidate shorter than MinBinaryTime",
+			1,
+			[]pairState{{50, 0.1}, {50, 0.1}, {500, 0.1}, {500, 0.1}},
+			nil,
+		},
+		{
+			"binary dissolved",
+			1,
+			[]pairState{{50, 0.1}, {50, 0.1}, {50, 0.1}, {50, 10}},
+			[]Event{{Kind: BinaryFormedEvent, Time: 2}, {Kind: BinaryDissolvedEvent, Time: 3}},
+		},
+		{
+			"massless tracer",
+			0,
+			[]pairState{{50, 0.1}, {5, 0.1}, {50, 0.1}, {50, 0.1}},
+			nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := EncounterDetector{G: 1, Distance: 10, BinaryDistance: 100, MinBinaryTime: 2}
+			var events []Event
+			for step, state := range c.states {
+				events = append(events, d.Update(pairBodies(state, c.mass), float64(step))...)
+			}
+			last := len(c.states) - 1
+			events = append(events, d.Finish(pairBodies(c.states[last], c.mass), float64(last))...)
+
+			if len(events) != len(c.want) {
+				t.Fatalf("%v events %v, expected %v", len(events), events, c.want)
+			}
+			for k, e := range events {
+				if e.Kind != c.want[k].Kind || e.Time != c.want[k].Time || e.I != 0 || e.J != 1 {
+					t.Errorf("event %v is %v at %v of %v and %v, expected %v at %v of 0 and 1", k, e.Kind, e.Time, e.I, e.J, c.want[k].Kind, c.want[k].Time)
+				}
+			}
+		})
+	}
+}
+
+
+func TestEncounterDetectorPericentre(t *testing.T) {
+	d := EncounterDetector{G: 1, Distance: 10, BinaryDistance: 0, MinBinaryTime: 2}
+	d.Update(pairBodies(pairState{5, 10}, 1), 0)
+	d.Update(pairBodies(pairState{2, 10}, 1), 1)
+	events := d.Update(pairBodies(pairState{50, 10}, 1), 2)
+	if len(events) != 1 {
+		t.Fatalf("%v events, expected 1", len(events))
+	}
+
+	// the speed is perpendicular to the separation at the closest sample, which is therefore the pericentre
+	if e := events[0]; e.Time != 1 || math.Abs(e.Pericentre - 2) > 1e-9 || math.Abs(e.RelativeVelocity - 10) > 1e-9 {
+		t.Errorf("pericentre %v at %v with %v, expected 2 at 1 with 10", e.Pericentre, e.Time, e.RelativeVelocity)
+	}
+}
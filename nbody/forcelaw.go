@@ -0,0 +1,347 @@
+
+package nbody
+
+
+import (
+	"fmt"
+	"math"
+)
+
+
+// ForceLaw is the pairwise interaction between orbs. Its methods and the GLSL functions returned by GLSL implement
+// the same formulas, so that the gravity compute shaders, the profiling compute shaders and the CPU path agree.
+//
+// The field at a target is the sum of PairField over all sources, where dv points from the target to the source and
+// inverseCube and inverseDistance are the softened counterparts of 1/r^3 and 1/r. The coupling of a source takes the
+// place of its mass, Acceleration and PotentialEnergy then turn the summed field and potential into the acceleration
+// and potential energy of the target.
+type ForceLaw interface {
+	Name() string
+	Charged() bool
+
+	SourceCoupling(mass, charge float64) float64
+	PairField(dv Vec3, inverseCube, inverseDistance, coupling float64) Vec3
+	PairPotential(inverseDistance, coupling float64) float64
+	Acceleration(field Vec3, mass, charge float64) Vec3
+	PotentialEnergy(potential, mass, charge float64) float64
+
+	// GLSL returns the definitions of source_coupling, pair_field, pair_potential, target_acceleration and
+	// target_potential_energy
+	GLSL() string
+}
+
+
+// Newtonian is plain Newtonian gravity.
+type Newtonian struct {
+	G float64
+}
+
+
+func (l Newtonian) Name() string {
+	return "newtonian"
+}
+
+
+func (l Newtonian) Charged() bool {
+	return false
+}
+
+
+func (l Newtonian) SourceCoupling(mass, charge float64) float64 {
+	return mass
+}
+
+
+func (l Newtonian) PairField(dv Vec3, inverseCube, inverseDistance, coupling float64) Vec3 {
+	return dv.Mul(coupling * inverseCube)
+}
+
+
+func (l Newtonian) PairPotential(inverseDistance, coupling float64) float64 {
+	return coupling * inverseDistance
+}
+
+
+func (l Newtonian) Acceleration(field Vec3, mass, charge float64) Vec3 {
+	return field.Mul(l.G)
+}
+
+
+func (l Newtonian) PotentialEnergy(potential, mass, charge float64) float64 {
+	return -l.G * mass * potential
+}
+
+
+func (l Newtonian) GLSL() string {
+	return fmt.Sprintf(`
+float source_coupling(const float mass, const float charge) {
+	return mass;
+}
+
+vec3 pair_field(const vec3 dv, const float inverse_cube, const float inverse_distance, const float coupling) {
+	return (coupling * inverse_cube) * dv;
+}
+
+float pair_potential(const float inverse_distance, const float coupling) {
+	return coupling * inverse_distance;
+}
+
+vec3 target_acceleration(const vec3 field, const float mass, const float charge) {
+	return %[1]v * field;
+}
+
+float target_potential_energy(const float potential, const float mass, const float charge) {
+	return -%[1]v * mass * potential;
+}
+`,
+		glslFloat(l.G),
+	)
+}
+
+
+// Yukawa is screened gravity, whose potential -G*m*exp(-r/Length)/r falls off exponentially beyond the screening
+// length. The softened distance 1/inverseDistance takes the place of r, which keeps the force the exact gradient of
+// the potential for Plummer softening.
+type Yukawa struct {
+	G, Length float64
+}
+
+
+func (l Yukawa) Name() string {
+	return "yukawa"
+}
+
+
+func (l Yukawa) Charged() bool {
+	return false
+}
+
+
+func (l Yukawa) SourceCoupling(mass, charge float64) float64 {
+	return mass
+}
+
+
+func (l Yukawa) PairField(dv Vec3, inverseCube, inverseDistance, coupling float64) Vec3 {
+	if inverseDistance == 0 {
+		return Vec3{}
+	}
+	x := 1 / (inverseDistance * l.Length)
+	return dv.Mul(coupling * inverseCube * (1 + x) * math.Exp(-x))
+}
+
+
+func (l Yukawa) PairPotential(inverseDistance, coupling float64) float64 {
+	if inverseDistance == 0 {
+		return 0
+	}
+	return coupling * inverseDistance * math.Exp(-1 / (inverseDistance * l.Length))
+}
+
+
+func (l Yukawa) Acceleration(field Vec3, mass, charge float64) Vec3 {
+	return field.Mul(l.G)
+}
+
+
+func (l Yukawa) PotentialEnergy(potential, mass, charge float64) float64 {
+	return -l.G * mass * potential
+}
+
+
+func (l Yukawa) GLSL() string {
+	return fmt.Sprintf(`
+float source_coupling(const float mass, const float charge) {
+	return mass;
+}
+
+vec3 pair_field(const vec3 dv, const float inverse_cube, const float inverse_distance, const float coupling) {
+	if( inverse_distance == 0 ) {
+		return vec3(0, 0, 0);
+	}
+	const float x = 1 / (inverse_distance * %[2]v);
+	return (coupling * inverse_cube * (1 + x) * exp(-x)) * dv;
+}
+
+float pair_potential(const float inverse_distance, const float coupling) {
+	if( inverse_distance == 0 ) {
+		return 0;
+	}
+	return coupling * inverse_distance * exp(-1 / (inverse_distance * %[2]v));
+}
+
+vec3 target_acceleration(const vec3 field, const float mass, const float charge) {
+	return %[1]v * field;
+}
+
+float target_potential_energy(const float potential, const float mass, const float charge) {
+	return -%[1]v * mass * potential;
+}
+`,
+		glslFloat(l.G),
+		glslFloat(l.Length),
+	)
+}
+
+
+// MOND scales the summed Newtonian acceleration a_N with the simple interpolating function
+// nu(y) = 1/2 + sqrt(1/4 + 1/y), y = |a_N|/A0, so that accelerations far below A0 approach sqrt(A0 * |a_N|).
+// The modified acceleration does not derive from a potential, hence the Newtonian potential energy is reported and
+// the total energy is not conserved.
+type MOND struct {
+	G, A0 float64
+}
+
+
+func (l MOND) Name() string {
+	return "mond"
+}
+
+
+func (l MOND) Charged() bool {
+	return false
+}
+
+
+func (l MOND) SourceCoupling(mass, charge float64) float64 {
+	return mass
+}
+
+
+func (l MOND) PairField(dv Vec3, inverseCube, inverseDistance, coupling float64) Vec3 {
+	return dv.Mul(coupling * inverseCube)
+}
+
+
+func (l MOND) PairPotential(inverseDistance, coupling float64) float64 {
+	return coupling * inverseDistance
+}
+
+
+func (l MOND) Acceleration(field Vec3, mass, charge float64) Vec3 {
+	newtonian := field.Mul(l.G)
+	magnitude := newtonian.Len()
+	if magnitude == 0 {
+		return newtonian
+	}
+	return newtonian.Mul(0.5 + math.Sqrt(0.25 + l.A0 / magnitude))
+}
+
+
+func (l MOND) PotentialEnergy(potential, mass, charge float64) float64 {
+	return -l.G * mass * potential
+}
+
+
+func (l MOND) GLSL() string {
+	return fmt.Sprintf(`
+float source_coupling(const float mass, const float charge) {
+	return mass;
+}
+
+vec3 pair_field(const vec3 dv, const float inverse_cube, const float inverse_distance, const float coupling) {
+	return (coupling * inverse_cube) * dv;
+}
+
+float pair_potential(const float inverse_distance, const float coupling) {
+	return coupling * inverse_distance;
+}
+
+vec3 target_acceleration(const vec3 field, const float mass, const float charge) {
+	const vec3 newtonian = %[1]v * field;
+	const float magnitude = length(newtonian);
+	if( magnitude == 0 ) {
+		return newtonian;
+	}
+	return (0.5 + sqrt(0.25 + %[2]v / magnitude)) * newtonian;
+}
+
+float target_potential_energy(const float potential, const float mass, const float charge) {
+	return -%[1]v * mass * potential;
+}
+`,
+		glslFloat(l.G),
+		glslFloat(l.A0),
+	)
+}
+
+
+// Coulomb is the electrostatic interaction between signed charges, K being the Coulomb constant in simulation units.
+// Like charges repel each other, and orbs without mass are not accelerated at all.
+type Coulomb struct {
+	K float64
+}
+
+
+func (l Coulomb) Name() string {
+	return "coulomb"
+}
+
+
+func (l Coulomb) Charged() bool {
+	return true
+}
+
+
+func (l Coulomb) SourceCoupling(mass, charge float64) float64 {
+	return charge
+}
+
+
+func (l Coulomb) PairField(dv Vec3, inverseCube, inverseDistance, coupling float64) Vec3 {
+	return dv.Mul(coupling * inverseCube)
+}
+
+
+func (l Coulomb) PairPotential(inverseDistance, coupling float64) float64 {
+	return coupling * inverseDistance
+}
+
+
+func (l Coulomb) Acceleration(field Vec3, mass, charge float64) Vec3 {
+	if mass == 0 {
+		return Vec3{}
+	}
+	return field.Mul(-l.K * charge / mass)
+}
+
+
+func (l Coulomb) PotentialEnergy(potential, mass, charge float64) float64 {
+	return l.K * charge * potential
+}
+
+
+func (l Coulomb) GLSL() string {
+	return fmt.Sprintf(`
+float source_coupling(const float mass, const float charge) {
+	return charge;
+}
+
+vec3 pair_field(const vec3 dv, const float inverse_cube, const float inverse_distance, const float coupling) {
+	return (coupling * inverse_cube) * dv;
+}
+
+float pair_potential(const float inverse_distance, const float coupling) {
+	return coupling * inverse_distance;
+}
+
+vec3 target_acceleration(const vec3 field, const float mass, const float charge) {
+	if( mass == 0 ) {
+		return vec3(0, 0, 0);
+	}
+	return (-%[1]v * charge / mass) * field;
+}
+
+float target_potential_energy(const float potential, const float mass, const float charge) {
+	return %[1]v * charge * potential;
+}
+`,
+		glslFloat(l.K),
+	)
+}
+
+
+// glslFloat formats x as a GLSL floating point literal without losing single precision.
+func glslFloat(x float64) string {
+	return fmt.Sprintf("%.9e", x)
+}
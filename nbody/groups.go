@@ -0,0 +1,191 @@
+
+package nbody
+
+
+import (
+	"math"
+	"sort"
+)
+
+
+// Group is a set of bodies, by index, found by FindGroups. Centre and Velocity are the mass weighted mean position
+// and velocity of the members, their plain means for massless tracers; Radius is the distance of the farthest member
+// from the centre.
+type Group struct {
+	Members []int
+	Mass float64
+	Centre, Velocity Vec3
+	Radius float64
+}
+
+
+// newGroup computes the properties of the group of the given members.
+func newGroup(bodies []Body, members []int) Group {
+	g := Group{Members: members}
+	w := make([]float64, len(members))
+	for k, i := range members {
+		w[k] = bodies[i].Mass
+		g.Mass += bodies[i].Mass
+	}
+	total := g.Mass
+	if total == 0 {
+		for k := range w {
+			w[k] = 1
+		}
+		total = float64(len(members))
+	}
+
+	for k, i := range members {
+		g.Centre = g.Centre.Add(bodies[i].Position.Mul(w[k] / total))
+		g.Velocity = g.Velocity.Add(bodies[i].Velocity.Mul(w[k] / total))
+	}
+	for _, i := range members {
+		g.Radius = math.Max(g.Radius, bodies[i].Position.Sub(g.Centre).Len())
+	}
+	return g
+}
+
+
+// FindGroups links every two bodies no farther than linkingLength from each other and returns the groups of linked
+// bodies with at least minMembers members, the heaviest first, the most populous first among massless ones.
+func FindGroups(bodies []Body, linkingLength float64, minMembers int) []Group {
+	// union find with path halving
+	parents := make([]int, len(bodies))
+	for i := range parents {
+		parents[i] = i
+	}
+	find := func(i int) int {
+		for parents[i] != i {
+			parents[i] = parents[parents[i]]
+			i = parents[i]
+		}
+		return i
+	}
+
+	forEachPair(bodies, linkingLength, func(i, j int) {
+		parents[find(j)] = find(i)
+	})
+
+	members := map[int][]int{}
+	for i := range bodies {
+		root := find(i)
+		members[root] = append(members[root], i)
+	}
+
+	var groups []Group
+	for _, m := range members {
+		if len(m) >= minMembers {
+			groups = append(groups, newGroup(bodies, m))
+		}
+	}
+	sortGroups(groups)
+	return groups
+}
+
+
+// forEachPair calls f for every pair of bodies i < j no farther from each other than distance. The bodies are sorted
+// into a grid of cells as large as distance, so only neighbouring cells need to be searched.
+func forEachPair(bodies []Body, distance float64, f func(i, j int)) {
+	cellOf := func(p Vec3) [3]int {
+		return [3]int{
+			int(math.Floor(p[0] / distance)),
+			int(math.Floor(p[1] / distance)),
+			int(math.Floor(p[2] / distance)),
+		}
+	}
+	cells := map[[3]int][]int{}
+	for i, b := range bodies {
+		cell := cellOf(b.Position)
+		cells[cell] = append(cells[cell], i)
+	}
+
+	distance2 := distance * distance
+	for i, b := range bodies {
+		cell := cellOf(b.Position)
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				for dz := -1; dz <= 1; dz++ {
+					for _, j := range cells[[3]int{cell[0] + dx, cell[1] + dy, cell[2] + dz}] {
+						if j <= i {
+							continue
+						}
+						d := bodies[j].Position.Sub(b.Position)
+						if d.Dot(d) <= distance2 {
+							f(i, j)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+
+func sortGroups(groups []Group) {
+	sort.Slice(groups, func(a, b int) bool {
+		if groups[a].Mass != groups[b].Mass {
+			return groups[a].Mass > groups[b].Mass
+		}
+		if len(groups[a].Members) != len(groups[b].Members) {
+			return len(groups[a].Members) > len(groups[b].Members)
+		}
+		return groups[a].Members[0] < groups[b].Members[0]
+	})
+}
+
+
+// Unbind removes the members of group whose kinetic energy relative to the group velocity exceeds their Plummer
+// softened potential energy in the field of the other members, and repeats as removing members changes the group
+// velocity and potential. A fast interloper can drag the group velocity along far enough to make every member look
+// unbound, so each round only removes the unbound members with at least half the energy of the most unbound one. The
+// energies are per unit of mass, so massless members are unbound alike.
+func Unbind(bodies []Body, group Group, G, softening float64) Group {
+	members := group.Members
+	for len(members) > 1 {
+		g := newGroup(bodies, members)
+		energies := make(map[int]float64, len(members))
+		var mostUnbound float64
+		for _, i := range members {
+			var potential float64
+			for _, j := range members {
+				if j == i {
+					continue
+				}
+				d := bodies[j].Position.Sub(bodies[i].Position)
+				potential -= G * bodies[j].Mass / math.Sqrt(d.Dot(d) + softening * softening)
+			}
+			v := bodies[i].Velocity.Sub(g.Velocity)
+			energies[i] = 0.5 * v.Dot(v) + potential
+			mostUnbound = math.Max(mostUnbound, energies[i])
+		}
+		if mostUnbound <= 0 {
+			break
+		}
+
+		var remaining []int
+		for _, i := range members {
+			if energies[i] < 0.5 * mostUnbound {
+				remaining = append(remaining, i)
+			}
+		}
+		members = remaining
+	}
+	if len(members) < 2 {
+		members = nil
+	}
+	return newGroup(bodies, members)
+}
+
+
+// UnbindGroups unbinds every group and keeps those that are left with at least minMembers members, and never those
+// that lost all of them.
+func UnbindGroups(bodies []Body, groups []Group, G, softening float64, minMembers int) []Group {
+	var unbound []Group
+	for _, group := range groups {
+		if g := Unbind(bodies, group, G, softening); len(g.Members) > 0 && len(g.Members) >= minMembers {
+			unbound = append(unbound, g)
+		}
+	}
+	sortGroups(unbound)
+	return unbound
+}
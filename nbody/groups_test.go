@@ -0,0 +1,120 @@
+
+package nbody
+
+
+import (
+	"testing"
+)
+
+
+// a clump of unit masses spaced one apart along x, starting at x
+func clump(x float64, numBodies int) []Body {
+	bodies := make([]Body, numBodies)
+	for i := range bodies {
+		bodies[i] = Body{Position: Vec3{x + float64(i), 0, 0}, Mass: 1}
+	}
+	return bodies
+}
+
+
+func TestFindGroups(t *testing.T) {
+	// clumps of 5 and 3 and a lone body between them
+	bodies := append(append(clump(0, 5), clump(100, 3)...), Body{Position: Vec3{50, 0, 0}, Mass: 1})
+
+	cases := []struct {
+		name string
+		linkingLength float64
+		minMembers int
+		want [][]int
+	}{
+		{"clumps", 1, 2, [][]int{{0, 1, 2, 3, 4}, {5, 6, 7}}},
+		{"lone body too", 1, 1, [][]int{{0, 1, 2, 3, 4}, {5, 6, 7}, {8}}},
+		{"small clump too small", 1, 4, [][]int{{0, 1, 2, 3, 4}}},
+		{"linking length too short", 0.5, 2, nil},
+		{"one group", 50, 2, [][]int{{0, 1, 2, 3, 4, 5, 6, 7, 8}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			groups := FindGroups(bodies, c.linkingLength, c.minMembers)
+			if len(groups) != len(c.want) {
+				t.Fatalf("%v groups, expected %v", len(groups), len(c.want))
+			}
+			for k, g := range groups {
+				if !sameMembers(g.Members, c.want[k]) {
+					t.Errorf("group %v has members %v, expected %v", k, g.Members, c.want[k])
+				}
+			}
+		})
+	}
+
+	g := FindGroups(bodies, 1, 2)[0]
+	if g.Mass != 5 || g.Centre != (Vec3{2, 0, 0}) || g.Radius != 2 {
+		t.Errorf("mass %v, centre %v and radius %v, expected 5, [2 0 0] and 2", g.Mass, g.Centre, g.Radius)
+	}
+}
+
+
+func sameMembers(members, want []int) bool {
+	if len(members) != len(want) {
+		return false
+	}
+	found := map[int]bool{}
+	for _, i := range members {
+		found[i] = true
+	}
+	for _, i := range want {
+		if !found[i] {
+			return false
+		}
+	}
+	return true
+}
+
+
+func TestUnbind(t *testing.T) {
+	// a cube of unit masses at rest, bound for G = 1
+	var cube []Body
+	for _, x := range []float64{0, 1} {
+		for _, y := range []float64{0, 1} {
+			for _, z := range []float64{0, 1} {
+				cube = append(cube, Body{Position: Vec3{x, y, z}, Mass: 1})
+			}
+		}
+	}
+
+	// the interloper drags the group velocity to about 11, which alone would unbind the whole cube
+	interloper := Body{Position: Vec3{0.5, 0.5, 0.5}, Velocity: Vec3{100, 0, 0}, Mass: 1}
+	// two bodies flying apart leave nothing bound
+	pair := []Body{{Velocity: Vec3{-100, 0, 0}, Mass: 1}, {Position: Vec3{1, 0, 0}, Velocity: Vec3{100, 0, 0}, Mass: 1}}
+
+	cases := []struct {
+		name string
+		bodies []Body
+		want []int
+	}{
+		{"bound cube", cube, []int{0, 1, 2, 3, 4, 5, 6, 7}},
+		{"fast interloper", append(append([]Body{}, cube...), interloper), []int{0, 1, 2, 3, 4, 5, 6, 7}},
+		{"unbound pair", pair, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			groups := FindGroups(c.bodies, 2, 2)
+			if len(groups) != 1 {
+				t.Fatalf("%v groups, expected 1", len(groups))
+			}
+			g := Unbind(c.bodies, groups[0], 1, 0.1)
+			if !sameMembers(g.Members, c.want) {
+				t.Errorf("members %v, expected %v", g.Members, c.want)
+			}
+
+			// emptied groups are dropped whatever the smallest number of members
+			wantGroups := 1
+			if len(c.want) == 0 {
+				wantGroups = 0
+			}
+			if unbound := UnbindGroups(c.bodies, groups, 1, 0.1, 0); len(unbound) != wantGroups {
+				t.Errorf("%v groups left after unbinding, expected %v", len(unbound), wantGroups)
+			}
+		})
+	}
+}
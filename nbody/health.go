@@ -0,0 +1,30 @@
+
+package nbody
+
+
+import (
+	"math"
+)
+
+
+// InvalidBodies returns the indices of the bodies whose position or velocity is not finite, or whose position is
+// farther than maxDistance from the origin; a maxDistance of 0 only checks for non-finite values.
+func InvalidBodies(bodies []Body, maxDistance float64) []int {
+	var invalid []int
+	for i, b := range bodies {
+		if !finite(b.Position) || !finite(b.Velocity) || (maxDistance > 0 && b.Position.Len() > maxDistance) {
+			invalid = append(invalid, i)
+		}
+	}
+	return invalid
+}
+
+
+func finite(v Vec3) bool {
+	for _, x := range v {
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+
+package nbody
+
+
+import (
+	"math"
+	"math/rand"
+)
+
+
+// NewDisk returns the initial conditions of the accuracy programs: a central orb of 1e11 lunar masses at index 0,
+// surrounded by a thin disk in the xz plane of orbs between 1000 and 22000 solar radii on circular orbits around the
+// barycentre of all other orbs. numMassive orbs, counting the central one, get a mass, the remaining ones are massless
+// tracers; 0 gives every orb a mass.
+func NewDisk(numBodies, numMassive int, G float64, r *rand.Rand) []Body {
+	bodies := make([]Body, numBodies)
+	bodies[0].Mass = 1e11
+
+	var sumMass float64 = bodies[0].Mass
+	var sumMassLocations Vec3
+	for i := 1; i < numBodies; i++ {
+		direction := Vec3{r.Float64() - 0.5, (r.Float64() - 0.5) * 0.05, r.Float64() - 0.5}
+		bodies[i].Position = direction.Mul((1000.0 + r.Float64() * 21000.0) / direction.Len())
+		if numMassive == 0 || i < numMassive {
+			bodies[i].Mass = math.Pow10(r.Intn(3)) * r.Float64()
+		}
+		sumMass += bodies[i].Mass
+		sumMassLocations = sumMassLocations.Add(bodies[i].Position.Mul(bodies[i].Mass))
+	}
+
+	for i := 1; i < numBodies; i++ {
+		b := &bodies[i]
+
+		// displacement vector from the barycentre without the current orb to the current orb
+		otherMass := sumMass - b.Mass
+		dv := b.Position.Sub(sumMassLocations.Sub(b.Position.Mul(b.Mass)).Mul(1 / otherMass))
+
+		magnitude := (otherMass / sumMass) * math.Sqrt(G * sumMass / dv.Len())
+		direction := dv.Cross(Vec3{0, 1, 0})
+		b.Velocity = direction.Mul(magnitude / direction.Len())
+	}
+	return bodies
+}
+
+
+// CircularOrbit is a two body system on a circular orbit in the xz plane, whose motion is known analytically.
+type CircularOrbit struct {
+	G float64
+	CentralMass, Mass float64
+	Radius float64		// separation of both bodies
+}
+
+
+func (o CircularOrbit) AngularVelocity() float64 {
+	return math.Sqrt(o.G * (o.CentralMass + o.Mass) / (o.Radius * o.Radius * o.Radius))
+}
+
+
+func (o CircularOrbit) Period() float64 {
+	return 2 * math.Pi / o.AngularVelocity()
+}
+
+
+// Bodies returns both bodies at time t, the central one at index 0, with the barycentre at rest in the origin.
+func (o CircularOrbit) Bodies(t float64) []Body {
+	omega := o.AngularVelocity()
+	totalMass := o.CentralMass + o.Mass
+	phase := omega * t
+	relative := Vec3{math.Cos(phase), 0, math.Sin(phase)}.Mul(o.Radius)
+	relativeVelocity := Vec3{-math.Sin(phase), 0, math.Cos(phase)}.Mul(o.Radius * omega)
+	return []Body{
+		{
+			Position: relative.Mul(-o.Mass / totalMass),
+			Velocity: relativeVelocity.Mul(-o.Mass / totalMass),
+			Mass: o.CentralMass,
+		},
+		{
+			Position: relative.Mul(o.CentralMass / totalMass),
+			Velocity: relativeVelocity.Mul(o.CentralMass / totalMass),
+			Mass: o.Mass,
+		},
+	}
+}
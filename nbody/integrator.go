@@ -0,0 +1,136 @@
+
+package nbody
+
+
+import (
+	"fmt"
+)
+
+
+// Integrator advances a system by one time step, the implementations mirror the gravity compute shaders of the
+// accuracy programs of the same name. They are written in terms of the drift and kick factors of the step, which
+// lets them integrate comoving systems as well.
+type Integrator interface {
+	Name() string
+	Step(s *System, dt float64)
+}
+
+
+// Euler is the update of the euler gravity compute shader.
+type Euler struct{}
+
+
+func (Euler) Name() string {
+	return "euler"
+}
+
+
+func (Euler) Step(s *System, dt float64) {
+	drift, kick := s.stepFactors(dt)
+	accelerations := s.stepAccelerations()
+	for i := range s.Bodies {
+		b := &s.Bodies[i]
+		b.Position = b.Position.Add(b.Velocity.Mul(drift)).Add(accelerations[i].Mul(0.5 * drift * kick))
+		b.Velocity = b.Velocity.Add(accelerations[i].Mul(kick))
+	}
+	s.advance(dt)
+}
+
+
+// Heun is the update of the heun gravity compute shader, which with a single force evaluation per step ends up
+// being the same update as the euler one.
+type Heun struct{}
+
+
+func (Heun) Name() string {
+	return "heun"
+}
+
+
+func (Heun) Step(s *System, dt float64) {
+	drift, kick := s.stepFactors(dt)
+	accelerations := s.stepAccelerations()
+	for i := range s.Bodies {
+		b := &s.Bodies[i]
+		velocity := b.Velocity.Add(accelerations[i].Mul(kick))
+		b.Position = b.Position.Add(b.Velocity.Add(velocity).Mul(0.5 * drift))
+		b.Velocity = velocity
+	}
+	s.advance(dt)
+}
+
+
+// Verlet is position verlet as done by the verlet compute shaders. It keeps the previous positions, like the second
+// location buffer of the shaders, so a Verlet value must only ever step a single system. The first step is the one of
+// the startup compute shader, velocities are estimated like in the verlet profiling compute shader.
+type Verlet struct {
+	previous []Vec3
+	lastDrift float64
+}
+
+
+func (*Verlet) Name() string {
+	return "verlet"
+}
+
+
+func (v *Verlet) Step(s *System, dt float64) {
+	drift, kick := s.stepFactors(dt)
+	accelerations := s.stepAccelerations()
+	current := make([]Vec3, len(s.Bodies))
+	for i := range s.Bodies {
+		b := &s.Bodies[i]
+		current[i] = b.Position
+		if len(v.previous) != len(s.Bodies) {
+			b.Position = b.Position.Add(b.Velocity.Mul(drift)).Add(accelerations[i].Mul(0.5 * drift * kick))
+		} else {
+			b.Position = b.Position.Add(b.Position.Sub(v.previous[i]).Mul(drift / v.lastDrift)).Add(accelerations[i].Mul(drift * kick))
+		}
+	}
+	v.previous = current
+	v.lastDrift = drift
+	s.advance(dt)
+
+	accelerations = s.stepAccelerations()
+	for i := range s.Bodies {
+		b := &s.Bodies[i]
+		b.Velocity = b.Position.Sub(v.previous[i]).Mul(1 / drift).Add(accelerations[i].Mul(0.5 * kick))
+	}
+}
+
+
+// Reset forgets the previous positions, the next step starts from the current positions and velocities again.
+func (v *Verlet) Reset() {
+	v.previous = nil
+}
+
+
+// Reverse swaps the previous with the current positions, like swapping the two location buffers of the verlet
+// programs, which lets the following steps retrace the ones before in reverse. The swap itself already is a step
+// back, so Reverse returns 1, see Reverser.
+func (v *Verlet) Reverse(s *System) int {
+	if len(v.previous) != len(s.Bodies) {
+		reverseVelocities(s)
+		return 0
+	}
+	for i := range s.Bodies {
+		b := &s.Bodies[i]
+		b.Position, v.previous[i] = v.previous[i], b.Position
+		b.Velocity = b.Velocity.Mul(-1)
+	}
+	return 1
+}
+
+
+// NewIntegrator returns a fresh integrator by its name, as returned by Name.
+func NewIntegrator(name string) (Integrator, error) {
+	switch name {
+	case "euler":
+		return Euler{}, nil
+	case "heun":
+		return Heun{}, nil
+	case "verlet":
+		return &Verlet{}, nil
+	}
+	return nil, fmt.Errorf("Unknown integrator '%s'", name)
+}
@@ -0,0 +1,97 @@
+
+package nbody
+
+
+import (
+	"math"
+	"math/rand"
+)
+
+
+// Lyapunov is the outcome of evolving a shadow copy of a system alongside it, the shadow being displaced by a tiny
+// phase space separation that is renormalised back to its initial size at fixed intervals (Benettin et al. 1980).
+// Exponents holds the running estimate of the maximal Lyapunov exponent after every renormalisation, at Times.
+type Lyapunov struct {
+	Exponent float64		// per unit of time
+	EFoldingTime float64		// 1 / Exponent
+	Times, Exponents []float64
+}
+
+
+// phaseSpaceDistance weighs velocities with timeScale, so that both halves of phase space have units of length.
+func phaseSpaceDistance(a, b []Body, timeScale float64) float64 {
+	var sum float64
+	for i := range a {
+		dx := a[i].Position.Sub(b[i].Position)
+		dv := a[i].Velocity.Sub(b[i].Velocity).Mul(timeScale)
+		sum += dx.Dot(dx) + dv.Dot(dv)
+	}
+	return math.Sqrt(sum)
+}
+
+
+// renormalise scales the separation of shadow from main by factor. Verlet keeps the previous positions as part of
+// its state, they are scaled along so that the shadow continues on the rescaled trajectory.
+func renormalise(main, shadow *System, mainIntegrator, shadowIntegrator Integrator, factor float64) {
+	for i := range shadow.Bodies {
+		m, s := &main.Bodies[i], &shadow.Bodies[i]
+		s.Position = m.Position.Add(s.Position.Sub(m.Position).Mul(factor))
+		s.Velocity = m.Velocity.Add(s.Velocity.Sub(m.Velocity).Mul(factor))
+	}
+
+	mainVerlet, ok := mainIntegrator.(*Verlet)
+	shadowVerlet, shadowOk := shadowIntegrator.(*Verlet)
+	if ok && shadowOk && len(mainVerlet.previous) == len(shadowVerlet.previous) {
+		for i := range shadowVerlet.previous {
+			shadowVerlet.previous[i] = mainVerlet.previous[i].Add(shadowVerlet.previous[i].Sub(mainVerlet.previous[i]).Mul(factor))
+		}
+	}
+}
+
+
+// MeasureLyapunov integrates a copy of initial and a shadow of it numSteps steps of dt, the shadow starting at a
+// phase space distance of separation in a random direction drawn from r. Every interval steps the growth of the
+// distance is accumulated and the shadow pulled back to separation. timeScale converts velocities into lengths for
+// the phase space distance, a typical orbital time of the system is a good choice.
+func MeasureLyapunov(initial *System, newIntegrator func() Integrator, dt float64, numSteps, interval int, separation, timeScale float64, r *rand.Rand) Lyapunov {
+	main, shadow := initial.Clone(), initial.Clone()
+	mainIntegrator, shadowIntegrator := newIntegrator(), newIntegrator()
+
+	// random direction in phase space
+	displacements := make([]Vec3, 2 * len(shadow.Bodies))
+	var norm float64
+	for i := range displacements {
+		displacements[i] = Vec3{r.NormFloat64(), r.NormFloat64(), r.NormFloat64()}
+		norm += displacements[i].Dot(displacements[i])
+	}
+	norm = separation / math.Sqrt(norm)
+	for i := range shadow.Bodies {
+		b := &shadow.Bodies[i]
+		b.Position = b.Position.Add(displacements[2 * i].Mul(norm))
+		b.Velocity = b.Velocity.Add(displacements[2 * i + 1].Mul(norm / timeScale))
+	}
+
+	var l Lyapunov
+	var sumLogGrowth float64
+	for step := 1; step <= numSteps; step++ {
+		mainIntegrator.Step(main, dt)
+		shadowIntegrator.Step(shadow, dt)
+		if step % interval != 0 && step != numSteps {
+			continue
+		}
+
+		distance := phaseSpaceDistance(main.Bodies, shadow.Bodies, timeScale)
+		sumLogGrowth += math.Log(distance / separation)
+		renormalise(main, shadow, mainIntegrator, shadowIntegrator, separation / distance)
+
+		elapsed := float64(step) * dt
+		l.Times = append(l.Times, elapsed)
+		l.Exponents = append(l.Exponents, sumLogGrowth / elapsed)
+	}
+
+	if len(l.Exponents) > 0 {
+		l.Exponent = l.Exponents[len(l.Exponents) - 1]
+		l.EFoldingTime = 1 / l.Exponent
+	}
+	return l
+}
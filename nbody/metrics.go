@@ -0,0 +1,78 @@
+
+package nbody
+
+
+import (
+	"fmt"
+	"math"
+)
+
+
+// Metrics compare the conserved quantities at the end of a run with those at its start. They are relative, or
+// normalized by the mass, so that runs with different numbers of orbs and setups can be compared.
+type Metrics struct {
+	EnergyError float64		// |(E - E0) / E0|
+	AngularMomentumError float64		// |L - L0| / |L0|
+	MomentumDrift float64		// |P - P0| / M, the change of the velocity of the barycentre
+	CenterOfMassDrift float64		// |C - C0|
+	StartVirialRatio, EndVirialRatio float64		// 2K / |W|
+	StartForceResidual, EndForceResidual float64		// |sum of F| / sum of |F|
+}
+
+
+// MetricNames are the CSV column names of the values returned by Metrics.Values, in the same order.
+var MetricNames = []string{
+	"energy_error",
+	"angular_momentum_error",
+	"momentum_drift",
+	"center_of_mass_drift",
+	"start_virial_ratio",
+	"end_virial_ratio",
+	"start_force_residual",
+	"end_force_residual",
+}
+
+
+func NewMetrics(start, end Conserved) Metrics {
+	return Metrics{
+		EnergyError: math.Abs((end.Energy() - start.Energy()) / start.Energy()),
+		AngularMomentumError: end.AngularMomentum.Sub(start.AngularMomentum).Len() / start.AngularMomentum.Len(),
+		MomentumDrift: end.LinearMomentum.Sub(start.LinearMomentum).Len() / start.Mass,
+		CenterOfMassDrift: end.CenterOfMass.Sub(start.CenterOfMass).Len(),
+		StartVirialRatio: virialRatio(start),
+		EndVirialRatio: virialRatio(end),
+		StartForceResidual: start.TotalForce.Len() / start.ForceMagnitudes,
+		EndForceResidual: end.TotalForce.Len() / end.ForceMagnitudes,
+	}
+}
+
+
+func virialRatio(c Conserved) float64 {
+	return 2 * c.KineticEnergy / math.Abs(c.PotentialEnergy)
+}
+
+
+func (m Metrics) Values() []float64 {
+	return []float64{
+		m.EnergyError,
+		m.AngularMomentumError,
+		m.MomentumDrift,
+		m.CenterOfMassDrift,
+		m.StartVirialRatio,
+		m.EndVirialRatio,
+		m.StartForceResidual,
+		m.EndForceResidual,
+	}
+}
+
+
+func (m Metrics) String() string {
+	s := ""
+	for i, value := range m.Values() {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("%s: %.3e", MetricNames[i], value)
+	}
+	return s
+}
@@ -0,0 +1,91 @@
+
+package nbody
+
+
+import (
+	"fmt"
+)
+
+
+// PostNewtonian corrects the acceleration of every body by the central body at index 0 with the 1PN and the 2.5PN
+// radiation reaction terms of the relative two body motion in harmonic coordinates (Kidder 1995), C being the speed
+// of light in simulation units. The central body itself is not corrected, which is exact in the test particle limit
+// and neglects its recoil against bodies of much smaller mass otherwise. The compute shaders also take the central
+// body to be at rest, since its velocity cannot be read while the other invocations update it.
+type PostNewtonian struct {
+	G, C float64
+	FirstOrder, RadiationReaction bool
+}
+
+
+func (pn PostNewtonian) Enabled() bool {
+	return pn.FirstOrder || pn.RadiationReaction
+}
+
+
+// Acceleration returns the correction for a body of the given mass at position x and velocity v relative to the
+// central body.
+func (pn PostNewtonian) Acceleration(x, v Vec3, mass, centralMass float64) Vec3 {
+	m := centralMass + mass
+	eta := centralMass * mass / (m * m)
+	r := x.Len()
+	n := x.Mul(1 / r)
+	rDot := n.Dot(v)
+	v2 := v.Dot(v)
+	gmr := pn.G * m / r
+	c2 := pn.C * pn.C
+
+	var sum Vec3
+	if pn.FirstOrder {
+		radial := (4 + 2 * eta) * gmr - (1 + 3 * eta) * v2 + 1.5 * eta * rDot * rDot
+		sum = sum.Add(n.Mul(radial).Add(v.Mul((4 - 2 * eta) * rDot)).Mul(gmr / (c2 * r)))
+	}
+	if pn.RadiationReaction {
+		radial := (18 * v2 + 2.0 / 3.0 * gmr - 25 * rDot * rDot) * rDot
+		tangential := 6 * v2 - 2 * gmr - 15 * rDot * rDot
+		sum = sum.Add(n.Mul(radial).Sub(v.Mul(tangential)).Mul(1.6 * eta * gmr * gmr / (c2 * c2 * pn.C * r)))
+	}
+	return sum
+}
+
+
+// GLSL returns the definition of post_newtonian_acceleration, the counterpart of Acceleration.
+func (pn PostNewtonian) GLSL() string {
+	var firstOrder, radiationReaction int
+	if pn.FirstOrder {
+		firstOrder = 1
+	}
+	if pn.RadiationReaction {
+		radiationReaction = 1
+	}
+
+	return fmt.Sprintf(`
+vec3 post_newtonian_acceleration(const vec3 x, const vec3 v, const float mass, const float central_mass) {
+	const float m = central_mass + mass;
+	const float eta = central_mass * mass / (m * m);
+	const float r = length(x);
+	const vec3 n = x / r;
+	const float r_dot = dot(n, v);
+	const float v2 = dot(v, v);
+	const float gmr = %[1]v * m / r;
+	const float c = %[2]v;
+
+	vec3 sum = vec3(0, 0, 0);
+#if %[3]v
+	const float radial_1pn = (4 + 2 * eta) * gmr - (1 + 3 * eta) * v2 + 1.5 * eta * r_dot * r_dot;
+	sum += (gmr / (c * c * r)) * (radial_1pn * n + ((4 - 2 * eta) * r_dot) * v);
+#endif
+#if %[4]v
+	const float radial_25pn = (18 * v2 + 0.666666667 * gmr - 25 * r_dot * r_dot) * r_dot;
+	const float tangential_25pn = 6 * v2 - 2 * gmr - 15 * r_dot * r_dot;
+	sum += (1.6 * eta * gmr * gmr / (c * c * c * c * c * r)) * (radial_25pn * n - tangential_25pn * v);
+#endif
+	return sum;
+}
+`,
+		glslFloat(pn.G),
+		glslFloat(pn.C),
+		firstOrder,
+		radiationReaction,
+	)
+}
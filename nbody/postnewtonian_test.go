@@ -0,0 +1,73 @@
+
+package nbody
+
+
+import (
+	"math"
+	"testing"
+)
+
+
+// TestFirstOrderPrecession integrates a massless test orb around a central mass with an artificially low speed of
+// light and compares the advance of its periapsis per orbit with the 1PN rate 6 pi G M / (c^2 a (1 - e^2)).
+func TestFirstOrderPrecession(t *testing.T) {
+	const (
+		G = 1.142602313e-4
+		centralMass = 1e11
+		semiMajorAxis = 10000.0
+		eccentricity = 0.5
+		c = 1250.0
+		numOrbits = 10
+		stepsPerOrbit = 4000
+		tolerance = 0.02		// relative, covers the higher post-Newtonian orders and the periapsis sampling
+	)
+
+	gm := G * centralMass
+	periapsis := semiMajorAxis * (1 - eccentricity)
+	s := &System{
+		Bodies: []Body{
+			{Mass: centralMass},
+			{
+				Position: Vec3{periapsis, 0, 0},
+				Velocity: Vec3{0, 0, math.Sqrt(gm * (1 + eccentricity) / periapsis)},
+			},
+		},
+		Law: Newtonian{G: G},
+		Kernel: NoSoftening,
+		PostNewtonian: &PostNewtonian{G: G, C: c, FirstOrder: true},
+	}
+
+	period := 2 * math.Pi * math.Sqrt(semiMajorAxis * semiMajorAxis * semiMajorAxis / gm)
+	dt := period / stepsPerOrbit
+
+	// direction of the Runge-Lenz vector, sampled whenever the orb passes the periapsis
+	periapsisAngle := func() float64 {
+		x, v := s.Bodies[1].Position, s.Bodies[1].Velocity
+		a := v.Cross(x.Cross(v)).Sub(x.Mul(gm / x.Len()))
+		return math.Atan2(a[2], a[0])
+	}
+
+	var angles []float64
+	integrator := &Verlet{}
+	r0, r1 := s.Bodies[1].Position.Len(), s.Bodies[1].Position.Len()
+	lastAngle := periapsisAngle()
+	for step := 0; step < (numOrbits + 1) * stepsPerOrbit; step++ {
+		integrator.Step(s, dt)
+		r2 := s.Bodies[1].Position.Len()
+		if r1 < r0 && r1 < r2 {
+			angles = append(angles, lastAngle)
+		}
+		r0, r1 = r1, r2
+		lastAngle = periapsisAngle()
+	}
+	if len(angles) < numOrbits {
+		t.Fatalf("found %d periapsis passages, expected at least %d", len(angles), numOrbits)
+	}
+
+	// the orb moves towards larger angles atan2(z, x), and so does its periapsis
+	measured := (angles[numOrbits - 1] - angles[0]) / float64(numOrbits - 1)
+	expected := 6 * math.Pi * gm / (c * c * semiMajorAxis * (1 - eccentricity * eccentricity))
+	if math.Abs(measured - expected) > tolerance * expected {
+		t.Errorf("periapsis advance per orbit: got %.6g, expected %.6g", measured, expected)
+	}
+}
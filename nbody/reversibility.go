@@ -0,0 +1,66 @@
+
+package nbody
+
+
+import (
+	"math"
+)
+
+
+// Reverser is implemented by integrators that keep state between steps and therefore have to turn around the
+// direction of time themselves. Reverse returns the number of steps that the reversal itself already took back.
+type Reverser interface {
+	Reverse(s *System) int
+}
+
+
+// Reverse turns around the direction of time of s as integrated by integrator, by reversing the velocities unless
+// the integrator is a Reverser. Comoving systems cannot be reversed this way, their step factors depend on the
+// direction of time.
+func Reverse(s *System, integrator Integrator) int {
+	if r, ok := integrator.(Reverser); ok {
+		return r.Reverse(s)
+	}
+	reverseVelocities(s)
+	return 0
+}
+
+
+func reverseVelocities(s *System) {
+	for i := range s.Bodies {
+		s.Bodies[i].Velocity = s.Bodies[i].Velocity.Mul(-1)
+	}
+}
+
+
+// Reversibility is the outcome of integrating a system forward, reversing it and integrating it back by as many
+// steps. Errors holds the distance of every body from its initial position, time reversible integrators only return
+// rounding errors, grown by the chaos of the system.
+type Reversibility struct {
+	Integrator string
+	Errors []float64
+	Max, RMS, Median float64
+}
+
+
+// MeasureReversibility integrates a copy of initial numSteps steps of dt forward and back again with integrator,
+// which must not have stepped any other system yet.
+func MeasureReversibility(initial *System, integrator Integrator, dt float64, numSteps int) Reversibility {
+	s := initial.Clone()
+	for i := 0; i < numSteps; i++ {
+		integrator.Step(s, dt)
+	}
+	for i := Reverse(s, integrator); i < numSteps; i++ {
+		integrator.Step(s, dt)
+	}
+
+	r := Reversibility{Integrator: integrator.Name(), Errors: make([]float64, len(s.Bodies))}
+	for i, b := range s.Bodies {
+		r.Errors[i] = b.Position.Sub(initial.Bodies[i].Position).Len()
+		r.Max = math.Max(r.Max, r.Errors[i])
+		r.RMS += r.Errors[i] * r.Errors[i]
+	}
+	r.RMS = math.Sqrt(r.RMS / float64(len(r.Errors)))
+	r.Median = Summarize(r.Errors).Median
+	return r
+}
@@ -0,0 +1,102 @@
+
+package nbody
+
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+
+// WriteSnapshot writes the bodies to a CSV file, one body per row with its position, velocity and mass. The
+// metadata comes first, as comment lines of the form "# key: value" sorted by key.
+func WriteSnapshot(fileName string, bodies []Body, metadata map[string]string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("Could not create '%s': %s", fileName, err)
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "# %s: %s\n", key, metadata[key])
+	}
+
+	fmt.Fprintln(w, "x, y, z, vx, vy, vz, mass")
+	for _, b := range bodies {
+		fmt.Fprintf(
+			w,
+			"%v, %v, %v, %v, %v, %v, %v\n",
+			b.Position[0], b.Position[1], b.Position[2],
+			b.Velocity[0], b.Velocity[1], b.Velocity[2],
+			b.Mass,
+		)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("Could not write to '%s': %s", fileName, err)
+	}
+	return nil
+}
+
+
+// ReadSnapshot reads a file written by WriteSnapshot.
+func ReadSnapshot(fileName string) ([]Body, map[string]string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not open '%s': %s", fileName, err)
+	}
+	defer file.Close()
+
+	var bodies []Body
+	metadata := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	header := true
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(text, "#") {
+			if key, value, found := strings.Cut(strings.TrimSpace(text[1:]), ":"); found {
+				metadata[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+			continue
+		}
+		if text == "" {
+			continue
+		}
+		if header {
+			header = false
+			continue
+		}
+
+		fields := strings.Split(text, ",")
+		if len(fields) != 7 {
+			return nil, nil, fmt.Errorf("'%s', line %d: expected 7 columns, found %d", fileName, line, len(fields))
+		}
+		var values [7]float64
+		for i, field := range fields {
+			values[i], err = strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("'%s', line %d: %s", fileName, line, err)
+			}
+		}
+		bodies = append(bodies, Body{
+			Position: Vec3{values[0], values[1], values[2]},
+			Velocity: Vec3{values[3], values[4], values[5]},
+			Mass: values[6],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("Could not read '%s': %s", fileName, err)
+	}
+	return bodies, metadata, nil
+}
@@ -0,0 +1,70 @@
+
+package nbody
+
+
+import (
+	"math"
+)
+
+
+// Kernel selects how the interaction is softened at short distances, its values match the SOFTENING_KERNEL defines
+// of the compute shaders.
+type Kernel int
+
+const (
+	PlummerSoftening Kernel = iota
+	SplineSoftening
+	NoSoftening
+)
+
+
+// InverseCube returns the softened counterpart of 1/r^3, see softened_inverse_cube in the compute shaders.
+func (k Kernel) InverseCube(r2, eps float64) float64 {
+	switch k {
+	case SplineSoftening:
+		h := 2.8 * eps
+		r := math.Sqrt(r2)
+		if r >= h {
+			return 1 / (r2 * r)
+		}
+		u := r / h
+		hInverseCube := 1 / (h * h * h)
+		if u < 0.5 {
+			return hInverseCube * (32.0 / 3.0 + u * u * (32.0 * u - 38.4))
+		}
+		return hInverseCube * (64.0 / 3.0 - 48.0 * u + 38.4 * u * u - 32.0 / 3.0 * u * u * u - 1.0 / 15.0 / (u * u * u))
+	case NoSoftening:
+		if r2 == 0 {
+			return 0
+		}
+		return 1 / (r2 * math.Sqrt(r2))
+	default:
+		brackets := r2 + eps * eps
+		return 1 / math.Sqrt(brackets * brackets * brackets)
+	}
+}
+
+
+// InverseDistance returns the softened counterpart of 1/r, see softened_inverse_distance in the compute shaders.
+func (k Kernel) InverseDistance(r2, eps float64) float64 {
+	switch k {
+	case SplineSoftening:
+		h := 2.8 * eps
+		r := math.Sqrt(r2)
+		if r >= h {
+			return 1 / r
+		}
+		u := r / h
+		if u < 0.5 {
+			return (2.8 - u * u * (16.0 / 3.0 + u * u * (6.4 * u - 9.6))) / h
+		}
+		return (3.2 - 1.0 / 15.0 / u - u * u * (32.0 / 3.0 + u * (-16.0 + u * (9.6 - 32.0 / 15.0 * u)))) / h
+	case NoSoftening:
+		if r2 == 0 {
+			return 0
+		}
+		return 1 / math.Sqrt(r2)
+	default:
+		return 1 / math.Sqrt(r2 + eps * eps)
+	}
+}
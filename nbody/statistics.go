@@ -0,0 +1,126 @@
+
+package nbody
+
+
+import (
+	"math"
+	"sort"
+)
+
+
+// Summary describes the distribution of one metric over repeated runs. The confidence interval is the 95% interval
+// of the mean from Student's t distribution, so it stays honest for the small numbers of runs a GPU budget allows.
+type Summary struct {
+	Count int
+	Mean, Median, StdDev float64
+	Min, Max float64
+	ConfidenceLow, ConfidenceHigh float64
+}
+
+
+// SummaryNames are the CSV column names of the values returned by Summary.Values, in the same order.
+var SummaryNames = []string{
+	"runs",
+	"mean",
+	"median",
+	"std_dev",
+	"min",
+	"max",
+	"ci95_low",
+	"ci95_high",
+}
+
+
+// two sided 95% quantiles of Student's t distribution for 1 to 30 degrees of freedom
+var studentT95 = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+
+func studentT(degreesOfFreedom int) float64 {
+	switch {
+	case degreesOfFreedom <= len(studentT95):
+		return studentT95[degreesOfFreedom - 1]
+	case degreesOfFreedom <= 40:
+		return 2.021
+	case degreesOfFreedom <= 60:
+		return 2.000
+	case degreesOfFreedom <= 120:
+		return 1.980
+	default:
+		return 1.960
+	}
+}
+
+
+// Summarize computes the summary statistics of values. A single value has a zero standard deviation and a
+// degenerate confidence interval, no values give a zero Summary.
+func Summarize(values []float64) Summary {
+	n := len(values)
+	if n == 0 {
+		return Summary{}
+	}
+
+	sorted := make([]float64, n)
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	s := Summary{Count: n, Min: sorted[0], Max: sorted[n - 1]}
+	if n % 2 == 1 {
+		s.Median = sorted[n / 2]
+	} else {
+		s.Median = 0.5 * (sorted[n / 2 - 1] + sorted[n / 2])
+	}
+
+	for _, value := range sorted {
+		s.Mean += value
+	}
+	s.Mean /= float64(n)
+
+	s.ConfidenceLow, s.ConfidenceHigh = s.Mean, s.Mean
+	if n > 1 {
+		for _, value := range sorted {
+			s.StdDev += (value - s.Mean) * (value - s.Mean)
+		}
+		s.StdDev = math.Sqrt(s.StdDev / float64(n - 1))
+
+		halfWidth := studentT(n - 1) * s.StdDev / math.Sqrt(float64(n))
+		s.ConfidenceLow -= halfWidth
+		s.ConfidenceHigh += halfWidth
+	}
+	return s
+}
+
+
+func (s Summary) Values() []float64 {
+	return []float64{
+		float64(s.Count),
+		s.Mean,
+		s.Median,
+		s.StdDev,
+		s.Min,
+		s.Max,
+		s.ConfidenceLow,
+		s.ConfidenceHigh,
+	}
+}
+
+
+// Histogram counts the values into numBins bins of equal width between min and max, values outside of them are
+// left out.
+func Histogram(values []float64, min, max float64, numBins int) []int {
+	counts := make([]int, numBins)
+	width := (max - min) / float64(numBins)
+	for _, value := range values {
+		bin := int(math.Floor((value - min) / width))
+		if value == max {
+			bin = numBins - 1
+		}
+		if bin >= 0 && bin < numBins {
+			counts[bin] += 1
+		}
+	}
+	return counts
+}
@@ -0,0 +1,161 @@
+
+package nbody
+
+
+import (
+	"math"
+	"sort"
+)
+
+
+// Frame is the frame of reference of the structural diagnostics: a centre, its velocity, and the unit vector of the
+// axis that the cylindrical coordinates R, phi and z are taken around.
+type Frame struct {
+	Centre, Velocity, Axis Vec3
+}
+
+
+// cylindrical returns the cylindrical radius of a body, its spherical radius, and its velocity in the cylindrical
+// components R, phi and z.
+func (f Frame) cylindrical(b Body) (radius, sphericalRadius float64, velocity Vec3) {
+	r := b.Position.Sub(f.Centre)
+	v := b.Velocity.Sub(f.Velocity)
+
+	height := r.Dot(f.Axis)
+	planar := r.Sub(f.Axis.Mul(height))
+	radius = planar.Len()
+	if radius == 0 {
+		return 0, r.Len(), Vec3{0, 0, v.Dot(f.Axis)}
+	}
+
+	eR := planar.Mul(1 / radius)
+	ePhi := f.Axis.Cross(eR)
+	return radius, r.Len(), Vec3{v.Dot(eR), v.Dot(ePhi), v.Dot(f.Axis)}
+}
+
+
+// weights are the masses of the bodies, or all 1 if the bodies are massless tracers.
+func weights(bodies []Body) []float64 {
+	w := make([]float64, len(bodies))
+	var total float64
+	for i, b := range bodies {
+		w[i] = b.Mass
+		total += b.Mass
+	}
+	if total == 0 {
+		for i := range w {
+			w[i] = 1
+		}
+	}
+	return w
+}
+
+
+// LagrangianRadii returns the radii around centre that enclose the given fractions of the total mass, of the total
+// number of bodies for massless tracers.
+func LagrangianRadii(bodies []Body, centre Vec3, fractions []float64) []float64 {
+	w := weights(bodies)
+	indices := make([]int, len(bodies))
+	radii := make([]float64, len(bodies))
+	var total float64
+	for i, b := range bodies {
+		indices[i] = i
+		radii[i] = b.Position.Sub(centre).Len()
+		total += w[i]
+	}
+	sort.Slice(indices, func(a, b int) bool { return radii[indices[a]] < radii[indices[b]] })
+
+	result := make([]float64, len(fractions))
+	for k, fraction := range fractions {
+		var enclosed float64
+		for _, i := range indices {
+			enclosed += w[i]
+			result[k] = radii[i]
+			if enclosed >= fraction * total {
+				break
+			}
+		}
+	}
+	return result
+}
+
+
+// ProfileBin is a radial shell, or a cylindrical ring, of a profile. The density is a volume density for spherical
+// profiles and a surface density for cylindrical ones. RotationVelocity is the mean of the velocity component phi,
+// the sigmas are the dispersions of the cylindrical velocity components. The density, the mean and the dispersions
+// are all weighted by mass, for massless tracers by count, which makes the density a number density.
+type ProfileBin struct {
+	Inner, Outer float64
+	Count int
+	Mass float64
+	EnclosedMass float64		// within Outer, including the bodies inside the innermost edge
+	Density float64
+	RotationVelocity float64
+	SigmaR, SigmaPhi, SigmaZ float64
+}
+
+
+// LogEdges returns numBins + 1 logarithmically spaced bin edges from inner to outer.
+func LogEdges(inner, outer float64, numBins int) []float64 {
+	edges := make([]float64, numBins + 1)
+	for i := range edges {
+		edges[i] = inner * math.Pow(outer / inner, float64(i) / float64(numBins))
+	}
+	return edges
+}
+
+
+// RadialProfile bins the bodies by their spherical radius, or by their cylindrical radius if cylindrical is set,
+// into the bins between consecutive edges.
+func RadialProfile(bodies []Body, frame Frame, edges []float64, cylindrical bool) []ProfileBin {
+	w := weights(bodies)
+	bins := make([]ProfileBin, len(edges) - 1)
+	binWeights := make([]float64, len(bins))
+	sums, sumSquares := make([]Vec3, len(bins)), make([]Vec3, len(bins))
+	var innerMass float64
+
+	for i, b := range bodies {
+		radius, sphericalRadius, velocity := frame.cylindrical(b)
+		if !cylindrical {
+			radius = sphericalRadius
+		}
+		if radius < edges[0] {
+			innerMass += b.Mass
+			continue
+		}
+
+		k := sort.Search(len(bins), func(k int) bool { return radius < edges[k + 1] })
+		if k == len(bins) {
+			continue
+		}
+		bins[k].Count += 1
+		bins[k].Mass += b.Mass
+		binWeights[k] += w[i]
+		sums[k] = sums[k].Add(velocity.Mul(w[i]))
+		sumSquares[k] = sumSquares[k].Add(Vec3{velocity[0] * velocity[0], velocity[1] * velocity[1], velocity[2] * velocity[2]}.Mul(w[i]))
+	}
+
+	enclosed := innerMass
+	for k := range bins {
+		bin := &bins[k]
+		bin.Inner, bin.Outer = edges[k], edges[k + 1]
+		enclosed += bin.Mass
+		bin.EnclosedMass = enclosed
+		if cylindrical {
+			bin.Density = binWeights[k] / (math.Pi * (bin.Outer * bin.Outer - bin.Inner * bin.Inner))
+		} else {
+			bin.Density = binWeights[k] / (4.0 / 3.0 * math.Pi * (bin.Outer * bin.Outer * bin.Outer - bin.Inner * bin.Inner * bin.Inner))
+		}
+		if binWeights[k] == 0 {
+			continue
+		}
+
+		mean := sums[k].Mul(1 / binWeights[k])
+		meanSquare := sumSquares[k].Mul(1 / binWeights[k])
+		bin.RotationVelocity = mean[1]
+		bin.SigmaR = math.Sqrt(math.Max(0, meanSquare[0] - mean[0] * mean[0]))
+		bin.SigmaPhi = math.Sqrt(math.Max(0, meanSquare[1] - mean[1] * mean[1]))
+		bin.SigmaZ = math.Sqrt(math.Max(0, meanSquare[2] - mean[2] * mean[2]))
+	}
+	return bins
+}
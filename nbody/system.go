@@ -0,0 +1,219 @@
+
+// Package nbody is the double precision CPU counterpart of the compute shaders of the accuracy programs, it serves as
+// the reference that the shaders and the analysis tools are checked against.
+package nbody
+
+
+import (
+	"math"
+	"runtime"
+	"sync"
+)
+
+
+// Body is a single orb, its charge is only used by charged force laws and its softening length only if the system
+// softens per body.
+type Body struct {
+	Position, Velocity Vec3
+	Mass, Charge, Softening float64
+}
+
+
+// System is a set of bodies together with the force law and softening that act between them. Only the first
+// NumSources bodies act as sources, the remaining ones are massless tracers, 0 makes every body a source.
+//
+// With a Cosmology the system is integrated in comoving coordinates, starting at ScaleFactor; time steps are then
+// steps in ln(a), which Time accumulates. PostNewtonian adds its corrections to the accelerations by the body at
+// index 0.
+type System struct {
+	Bodies []Body
+	Law ForceLaw
+	Kernel Kernel
+	Softening float64
+	PerBodySoftening bool
+	NumSources int
+	Time float64
+
+	Cosmology *Cosmology
+	ScaleFactor float64
+
+	PostNewtonian *PostNewtonian
+}
+
+
+func (s *System) numSources() int {
+	if s.NumSources > 0 && s.NumSources < len(s.Bodies) {
+		return s.NumSources
+	}
+	return len(s.Bodies)
+}
+
+
+// pairSoftening mirrors the shaders, which soften a pair with the larger of both softening lengths.
+func (s *System) pairSoftening(i, j int) float64 {
+	if s.PerBodySoftening {
+		return max(s.Bodies[i].Softening, s.Bodies[j].Softening)
+	}
+	return s.Softening
+}
+
+
+// forEachBody calls f for every body index, spread over all CPUs.
+func (s *System) forEachBody(f func(i int)) {
+	numWorkers := runtime.NumCPU()
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(s.Bodies); i += numWorkers {
+				f(i)
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+
+// Accelerations returns the acceleration of every body, summed the same way as in the gravity compute shaders.
+func (s *System) Accelerations() []Vec3 {
+	accelerations := make([]Vec3, len(s.Bodies))
+	numSources := s.numSources()
+	s.forEachBody(func(i int) {
+		target := &s.Bodies[i]
+		var field Vec3
+		for j := 0; j < numSources; j++ {
+			source := &s.Bodies[j]
+			dv := source.Position.Sub(target.Position)
+			r2 := dv.Dot(dv)
+			eps := s.pairSoftening(i, j)
+			field = field.Add(s.Law.PairField(
+				dv,
+				s.Kernel.InverseCube(r2, eps),
+				s.Kernel.InverseDistance(r2, eps),
+				s.Law.SourceCoupling(source.Mass, source.Charge),
+			))
+		}
+		accelerations[i] = s.Law.Acceleration(field, target.Mass, target.Charge)
+	})
+	return accelerations
+}
+
+
+// stepFactors returns the drift and kick factors of a step of size dt, both of which are dt without a cosmology.
+func (s *System) stepFactors(dt float64) (drift, kick float64) {
+	if s.Cosmology == nil {
+		return dt, dt
+	}
+	a1 := s.ScaleFactor * math.Exp(dt)
+	return s.Cosmology.DriftFactor(s.ScaleFactor, a1), s.Cosmology.KickFactor(s.ScaleFactor, a1)
+}
+
+
+// advance moves the time, and the scale factor of a comoving system, forward by a step of size dt.
+func (s *System) advance(dt float64) {
+	if s.Cosmology != nil {
+		s.ScaleFactor *= math.Exp(dt)
+	}
+	s.Time += dt
+}
+
+
+// stepAccelerations returns the accelerations that the integrators kick with, which for a comoving system include
+// the background term of the vacuum boundary, and the post-Newtonian corrections if there are any.
+func (s *System) stepAccelerations() []Vec3 {
+	accelerations := s.Accelerations()
+	if s.Cosmology != nil {
+		background := s.Cosmology.BackgroundAcceleration()
+		for i := range accelerations {
+			accelerations[i] = accelerations[i].Add(s.Bodies[i].Position.Mul(background))
+		}
+	}
+	if s.PostNewtonian != nil && s.PostNewtonian.Enabled() {
+		central := s.Bodies[0]
+		for i := 1; i < len(s.Bodies); i++ {
+			b := s.Bodies[i]
+			accelerations[i] = accelerations[i].Add(s.PostNewtonian.Acceleration(
+				b.Position.Sub(central.Position),
+				b.Velocity.Sub(central.Velocity),
+				b.Mass,
+				central.Mass,
+			))
+		}
+	}
+	return accelerations
+}
+
+
+// PotentialEnergies returns the potential energy of every body in the field of all other sources, which counts
+// every pair twice, like the profiling compute shaders do before halving.
+func (s *System) PotentialEnergies() []float64 {
+	energies := make([]float64, len(s.Bodies))
+	numSources := s.numSources()
+	s.forEachBody(func(i int) {
+		target := &s.Bodies[i]
+		potential := 0.0
+		for j := 0; j < numSources; j++ {
+			if j == i {
+				continue
+			}
+			source := &s.Bodies[j]
+			dv := source.Position.Sub(target.Position)
+			potential += s.Law.PairPotential(
+				s.Kernel.InverseDistance(dv.Dot(dv), s.pairSoftening(i, j)),
+				s.Law.SourceCoupling(source.Mass, source.Charge),
+			)
+		}
+		energies[i] = s.Law.PotentialEnergy(potential, target.Mass, target.Charge)
+	})
+	return energies
+}
+
+
+// Conserved holds the quantities that the profiling compute shaders reduce over all orbs. ForceMagnitudes is the sum
+// of the magnitudes of the forces on the single bodies, the scale against which TotalForce should vanish.
+type Conserved struct {
+	AngularMomentum Vec3
+	KineticEnergy, PotentialEnergy float64
+	TotalForce Vec3
+	ForceMagnitudes float64
+	LinearMomentum Vec3
+	Mass float64
+	CenterOfMass Vec3
+}
+
+
+func (c Conserved) Energy() float64 {
+	return c.KineticEnergy + c.PotentialEnergy
+}
+
+
+func (s *System) Conserved() Conserved {
+	var c Conserved
+	accelerations := s.Accelerations()
+	potentialEnergies := s.PotentialEnergies()
+	for i, b := range s.Bodies {
+		force := accelerations[i].Mul(b.Mass)
+		c.AngularMomentum = c.AngularMomentum.Add(b.Position.Cross(b.Velocity).Mul(b.Mass))
+		c.KineticEnergy += 0.5 * b.Mass * b.Velocity.Dot(b.Velocity)
+		c.PotentialEnergy += 0.5 * potentialEnergies[i]
+		c.TotalForce = c.TotalForce.Add(force)
+		c.ForceMagnitudes += force.Len()
+		c.LinearMomentum = c.LinearMomentum.Add(b.Velocity.Mul(b.Mass))
+		c.Mass += b.Mass
+		c.CenterOfMass = c.CenterOfMass.Add(b.Position.Mul(b.Mass))
+	}
+	// without any mass the centre of mass stays at the origin
+	if c.Mass > 0 {
+		c.CenterOfMass = c.CenterOfMass.Mul(1 / c.Mass)
+	}
+	return c
+}
+
+
+// Clone returns a deep copy of the system, sharing only the force law, the cosmology and the post-Newtonian corrections.
+func (s *System) Clone() *System {
+	clone := *s
+	clone.Bodies = append([]Body(nil), s.Bodies...)
+	return &clone
+}
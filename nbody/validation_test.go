@@ -0,0 +1,249 @@
+
+package nbody
+
+
+import (
+	"math"
+	"testing"
+)
+
+
+// The validation problems run in units with G = 1. Euler and Heun are first order integrators and get tolerances
+// about the size of their error at the chosen step sizes, Verlet is second order and held to far tighter ones. The
+// tolerances are relative unless noted otherwise.
+
+
+// tolerances of a validation problem, by integrator name
+type tolerances map[string]float64
+
+
+var integratorNames = []string{"euler", "heun", "verlet"}
+
+
+func newTestIntegrator(t *testing.T, name string) Integrator {
+	integrator, err := NewIntegrator(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return integrator
+}
+
+
+func newTestSystem(bodies []Body) *System {
+	return &System{
+		Bodies: bodies,
+		Law: Newtonian{G: 1},
+		Kernel: NoSoftening,
+	}
+}
+
+
+// keplerOrbit integrates a two body orbit and measures its period, from the time the relative position needs to sweep
+// a full turn, and its apocentre, the largest separation during that turn.
+func keplerOrbit(s *System, integrator Integrator, dt, maxTime float64) (period, apocentre float64) {
+	relativeAngle := func() float64 {
+		d := s.Bodies[1].Position.Sub(s.Bodies[0].Position)
+		return math.Atan2(d[2], d[0])
+	}
+
+	var swept float64
+	angle := relativeAngle()
+	for s.Time < maxTime {
+		integrator.Step(s, dt)
+		apocentre = math.Max(apocentre, s.Bodies[1].Position.Sub(s.Bodies[0].Position).Len())
+
+		next := relativeAngle()
+		delta := math.Remainder(next - angle, 2 * math.Pi)
+		if swept + delta >= 2 * math.Pi {
+			// interpolate linearly within the last step
+			return s.Time - dt * (swept + delta - 2 * math.Pi) / delta, apocentre
+		}
+		swept += delta
+		angle = next
+	}
+	return math.Inf(1), apocentre
+}
+
+
+func TestCircularKepler(t *testing.T) {
+	const stepsPerOrbit = 20000
+
+	periodTolerance := tolerances{"euler": 1e-2, "heun": 1e-2, "verlet": 1e-7}
+	radiusTolerance := tolerances{"euler": 1e-2, "heun": 1e-2, "verlet": 1e-7}
+
+	orbit := CircularOrbit{G: 1, CentralMass: 1, Mass: 1e-3, Radius: 1}
+	for _, name := range integratorNames {
+		t.Run(name, func(t *testing.T) {
+			s := newTestSystem(orbit.Bodies(0))
+			period, apocentre := keplerOrbit(s, newTestIntegrator(t, name), orbit.Period() / stepsPerOrbit, 2 * orbit.Period())
+			t.Logf("period %.9g, expected %.9g", period, orbit.Period())
+			t.Logf("largest separation %.9g, expected %.9g", apocentre, orbit.Radius)
+			if e := math.Abs(period / orbit.Period() - 1); e > periodTolerance[name] {
+				t.Errorf("relative period error %.3e exceeds %.0e", e, periodTolerance[name])
+			}
+			if e := math.Abs(apocentre / orbit.Radius - 1); e > radiusTolerance[name] {
+				t.Errorf("relative radius error %.3e exceeds %.0e", e, radiusTolerance[name])
+			}
+		})
+	}
+}
+
+
+func TestEccentricKepler(t *testing.T) {
+	const (
+		semiMajorAxis = 1.0
+		eccentricity = 0.6
+		stepsPerOrbit = 20000
+	)
+
+	periodTolerance := tolerances{"euler": 1e-2, "heun": 1e-2, "verlet": 1e-5}
+	apocentreTolerance := tolerances{"euler": 1e-2, "heun": 1e-2, "verlet": 1e-5}
+
+	// start at the pericentre, the central body carries all but a negligible part of the mass
+	totalMass := 1.0 + 1e-3
+	pericentre := semiMajorAxis * (1 - eccentricity)
+	expectedApocentre := semiMajorAxis * (1 + eccentricity)
+	expectedPeriod := 2 * math.Pi * math.Sqrt(semiMajorAxis * semiMajorAxis * semiMajorAxis / totalMass)
+	speed := math.Sqrt(totalMass * (1 + eccentricity) / pericentre)
+
+	for _, name := range integratorNames {
+		t.Run(name, func(t *testing.T) {
+			s := newTestSystem([]Body{
+				{Position: Vec3{-1e-3 / totalMass * pericentre, 0, 0}, Velocity: Vec3{0, 0, -1e-3 / totalMass * speed}, Mass: 1},
+				{Position: Vec3{1 / totalMass * pericentre, 0, 0}, Velocity: Vec3{0, 0, 1 / totalMass * speed}, Mass: 1e-3},
+			})
+			period, apocentre := keplerOrbit(s, newTestIntegrator(t, name), expectedPeriod / stepsPerOrbit, 2 * expectedPeriod)
+			t.Logf("period %.9g, expected %.9g", period, expectedPeriod)
+			t.Logf("apocentre %.9g, expected %.9g", apocentre, expectedApocentre)
+			if e := math.Abs(period / expectedPeriod - 1); e > periodTolerance[name] {
+				t.Errorf("relative period error %.3e exceeds %.0e", e, periodTolerance[name])
+			}
+			if e := math.Abs(apocentre / expectedApocentre - 1); e > apocentreTolerance[name] {
+				t.Errorf("relative apocentre error %.3e exceeds %.0e", e, apocentreTolerance[name])
+			}
+		})
+	}
+}
+
+
+// the Chenciner-Montgomery figure-eight with the initial conditions of Simo, its period is 6.32591398
+var figureEight = []Body{
+	{Position: Vec3{0.97000436, 0, -0.24308753}, Velocity: Vec3{0.466203685, 0, 0.43236573}, Mass: 1},
+	{Position: Vec3{-0.97000436, 0, 0.24308753}, Velocity: Vec3{0.466203685, 0, 0.43236573}, Mass: 1},
+	{Position: Vec3{0, 0, 0}, Velocity: Vec3{-0.93240737, 0, -0.86473146}, Mass: 1},
+}
+
+
+// TestFigureEight integrates the Chenciner-Montgomery choreography of three equal masses over one period and compares
+// the final with the initial positions and the energy.
+func TestFigureEight(t *testing.T) {
+	const (
+		period = 6.32591398
+		numSteps = 20000
+	)
+
+	positionTolerance := tolerances{"euler": 5e-2, "heun": 5e-2, "verlet": 1e-6}		// absolute, the orbit spans about 2
+	energyTolerance := tolerances{"euler": 1e-2, "heun": 1e-2, "verlet": 1e-10}
+
+	for _, name := range integratorNames {
+		t.Run(name, func(t *testing.T) {
+			s := newTestSystem(append([]Body(nil), figureEight...))
+			startEnergy := s.Conserved().Energy()
+			integrator := newTestIntegrator(t, name)
+			for i := 0; i < numSteps; i++ {
+				integrator.Step(s, period / numSteps)
+			}
+
+			positionError := PositionError(s.Bodies, figureEight)
+			energyError := math.Abs(s.Conserved().Energy() / startEnergy - 1)
+			t.Logf("position error %.3e, energy error %.3e", positionError, energyError)
+			if positionError > positionTolerance[name] {
+				t.Errorf("position error after one period %.3e exceeds %.0e", positionError, positionTolerance[name])
+			}
+			if energyError > energyTolerance[name] {
+				t.Errorf("relative energy error %.3e exceeds %.0e", energyError, energyTolerance[name])
+			}
+		})
+	}
+}
+
+
+// TestPythagorean integrates Burrau's problem, masses 3, 4 and 5 at rest on the corners of a 3-4-5 triangle. Fixed
+// step sizes cannot follow the later, much closer, encounters that end in the ejection of the lightest body, so the
+// test checks the conserved quantities instead of the outcome. Verlet is taken through the first close encounter of
+// the masses 4 and 5 and has to place it where the literature does, within 0.01 of each other at t = 1.88. The first
+// order integrators gain several times the initial energy there and stop just before it.
+func TestPythagorean(t *testing.T) {
+	const dt = 1e-5
+
+	duration := map[string]float64{"euler": 1.5, "heun": 1.5, "verlet": 4}
+	energyTolerance := tolerances{"euler": 1e-4, "heun": 1e-4, "verlet": 1e-8}
+	angularMomentumTolerance := tolerances{"euler": 1e-5, "heun": 1e-5, "verlet": 1e-7}		// absolute, it starts at 0
+	momentumTolerance := 1e-7		// absolute, momentum is conserved by all integrators up to rounding
+
+	// Szebehely and Peters give the first close encounter of the masses 4 and 5 as a separation of 0.0097 at t = 1.879
+	const closestApproach, closestApproachTolerance = 0.0097, 5e-4		// absolute, about 5%
+	const closestApproachTime, closestApproachTimeTolerance = 1.879, 5e-3		// absolute, 500 steps
+
+	for _, name := range integratorNames {
+		t.Run(name, func(t *testing.T) {
+			s := newTestSystem([]Body{
+				{Position: Vec3{1, 0, 3}, Mass: 3},
+				{Position: Vec3{-2, 0, -1}, Mass: 4},
+				{Position: Vec3{1, 0, -1}, Mass: 5},
+			})
+			start := s.Conserved()
+			integrator := newTestIntegrator(t, name)
+			closest, closestTime := math.Inf(1), 0.0
+			for s.Time < duration[name] {
+				integrator.Step(s, dt)
+				if separation := s.Bodies[2].Position.Sub(s.Bodies[1].Position).Len(); separation < closest {
+					closest, closestTime = separation, s.Time
+				}
+			}
+			end := s.Conserved()
+
+			if name == "verlet" {
+				t.Logf("closest approach of the masses 4 and 5 %.6f at t = %.6f", closest, closestTime)
+				if math.Abs(closest - closestApproach) > closestApproachTolerance {
+					t.Errorf("closest approach of the masses 4 and 5 %.6f, expected %v within %.0e", closest, closestApproach, closestApproachTolerance)
+				}
+				if math.Abs(closestTime - closestApproachTime) > closestApproachTimeTolerance {
+					t.Errorf("closest approach at t = %.6f, expected %v within %.0e", closestTime, closestApproachTime, closestApproachTimeTolerance)
+				}
+			}
+
+			energyError := math.Abs(end.Energy() / start.Energy() - 1)
+			momentumError := end.LinearMomentum.Sub(start.LinearMomentum).Len()
+			angularMomentumError := end.AngularMomentum.Sub(start.AngularMomentum).Len()
+			t.Logf("energy error %.3e, momentum error %.3e, angular momentum error %.3e", energyError, momentumError, angularMomentumError)
+			if energyError > energyTolerance[name] {
+				t.Errorf("relative energy error %.3e exceeds %.0e", energyError, energyTolerance[name])
+			}
+			if momentumError > momentumTolerance {
+				t.Errorf("momentum error %.3e exceeds %.0e", momentumError, momentumTolerance)
+			}
+			if angularMomentumError > angularMomentumTolerance[name] {
+				t.Errorf("angular momentum error %.3e exceeds %.0e", angularMomentumError, angularMomentumTolerance[name])
+			}
+		})
+	}
+}
+
+
+// TestVerletReversibility integrates the figure-eight a period forward and back again, position Verlet retraces its
+// steps up to rounding errors.
+func TestVerletReversibility(t *testing.T) {
+	const (
+		period = 6.32591398
+		numSteps = 20000
+		tolerance = 1e-9		// absolute
+	)
+
+	s := newTestSystem(append([]Body(nil), figureEight...))
+	r := MeasureReversibility(s, &Verlet{}, period / numSteps, numSteps)
+	t.Logf("largest return error %.3e", r.Max)
+	if r.Max > tolerance {
+		t.Errorf("largest return error %.3e exceeds %.0e", r.Max, tolerance)
+	}
+}
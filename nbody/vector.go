@@ -0,0 +1,45 @@
+
+package nbody
+
+
+import (
+	"math"
+)
+
+
+// Vec3 is a double precision vector, since the CPU path serves as the reference for the single precision shaders.
+type Vec3 [3]float64
+
+
+func (v Vec3) Add(w Vec3) Vec3 {
+	return Vec3{v[0] + w[0], v[1] + w[1], v[2] + w[2]}
+}
+
+
+func (v Vec3) Sub(w Vec3) Vec3 {
+	return Vec3{v[0] - w[0], v[1] - w[1], v[2] - w[2]}
+}
+
+
+func (v Vec3) Mul(c float64) Vec3 {
+	return Vec3{v[0] * c, v[1] * c, v[2] * c}
+}
+
+
+func (v Vec3) Dot(w Vec3) float64 {
+	return v[0] * w[0] + v[1] * w[1] + v[2] * w[2]
+}
+
+
+func (v Vec3) Cross(w Vec3) Vec3 {
+	return Vec3{
+		v[1] * w[2] - v[2] * w[1],
+		v[2] * w[0] - v[0] * w[2],
+		v[0] * w[1] - v[1] * w[0],
+	}
+}
+
+
+func (v Vec3) Len() float64 {
+	return math.Sqrt(v.Dot(v))
+}
@@ -3,6 +3,7 @@ package main
 
 
 import (
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -18,6 +19,8 @@ import (
 	"github.com/go-gl/gl/v4.5-core/gl"
 	"github.com/go-gl/glfw/v3.2/glfw"
 	mgl "github.com/go-gl/mathgl/mgl32"
+
+	"github.com/ocean-of-serenity/gravsim/headless"
 )
 
 
@@ -73,33 +76,47 @@ var scrollDirection float32
 
 var profilingLog Duration
 var profilingFileName string
+var startTime = time.Now()
+
+var headlessMode = flag.Bool("headless", false, "run on a surfaceless EGL context without a window and render nothing")
 
 
 func main() {
 	// misc setup
+	flag.Parse()
 	profilingFileName = fmt.Sprintf("performance-euler_interleaved-%s.csv", time.Now().Format("2006_01_02_15_04_05"))
 
 
-	// initialize GLFW and OpenGL
-	if err := glfw.Init(); err != nil {
-		log.Fatalln("Failed to initialize glfw:", err)
-	}
-	defer glfw.Terminate()
+	// initialize GLFW and OpenGL, or only OpenGL on a surfaceless context in headless mode
+	var window *glfw.Window
+	if *headlessMode {
+		context, err := headless.NewContext(4, 5)
+		if err != nil {
+			log.Fatalln("Failed to create headless context", err)
+		}
+		defer context.Destroy()
+	} else {
+		if err := glfw.Init(); err != nil {
+			log.Fatalln("Failed to initialize glfw:", err)
+		}
+		defer glfw.Terminate()
 
-	glfw.WindowHint(glfw.ContextVersionMajor, 4)
-	glfw.WindowHint(glfw.ContextVersionMinor, 5)
-	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
-//	glfw.WindowHint(glfw.OpenGLDebugContext, glfw.True)
+		glfw.WindowHint(glfw.ContextVersionMajor, 4)
+		glfw.WindowHint(glfw.ContextVersionMinor, 5)
+		glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
+//		glfw.WindowHint(glfw.OpenGLDebugContext, glfw.True)
 
-	window, err := glfw.CreateWindow(initialWindowWidth, initialWindowHeight, "Gravity Simulation - Euler Interleaved", nil, nil)
-	if err != nil {
-		log.Fatalln("Failed to create window", err)
-	}
-	defer window.Destroy()
+		var err error
+		window, err = glfw.CreateWindow(initialWindowWidth, initialWindowHeight, "Gravity Simulation - Euler Interleaved", nil, nil)
+		if err != nil {
+			log.Fatalln("Failed to create window", err)
+		}
+		defer window.Destroy()
 
-	window.MakeContextCurrent()
+		window.MakeContextCurrent()
 
-	glfw.SwapInterval(0)
+		glfw.SwapInterval(0)
+	}
 
 	if err := gl.Init(); err != nil {
 		log.Fatalln("Failed to initialize glow", err)
@@ -193,21 +210,23 @@ func main() {
 		gl.ProgramUniformMatrix4fv(sphereProgram, sphereProgramProjection, 1, false, &projection[0])
 		gl.ProgramUniformMatrix4fv(axisProgram, axisProgramProjection, 1, false, &projection[0])
 
-		window.SetFramebufferSizeCallback(
-			func(_ *glfw.Window, width, height int) {
-				gl.Viewport(0, 0, int32(width), int32(height))
-
-				projection := mgl.Perspective(
-					math.Pi / 4 * (float32(height) / float32(initialWindowHeight)),
-					float32(width) / float32(height),
-					32.0,
-					88000.0,
-				)
-
-				gl.ProgramUniformMatrix4fv(sphereProgram, sphereProgramProjection, 1, false, &projection[0])
-				gl.ProgramUniformMatrix4fv(axisProgram, axisProgramProjection, 1, false, &projection[0])
-			},
-		)
+		if window != nil {
+			window.SetFramebufferSizeCallback(
+				func(_ *glfw.Window, width, height int) {
+					gl.Viewport(0, 0, int32(width), int32(height))
+
+					projection := mgl.Perspective(
+						math.Pi / 4 * (float32(height) / float32(initialWindowHeight)),
+						float32(width) / float32(height),
+						32.0,
+						88000.0,
+					)
+
+					gl.ProgramUniformMatrix4fv(sphereProgram, sphereProgramProjection, 1, false, &projection[0])
+					gl.ProgramUniformMatrix4fv(axisProgram, axisProgramProjection, 1, false, &projection[0])
+				},
+			)
+		}
 
 		view := mgl.LookAtV(camera.root, camera.watch, mgl.Vec3{0, 1, 0})
 		sphereProgramView = gl.GetUniformLocation(sphereProgram, gl.Str("view\x00"))
@@ -232,51 +251,53 @@ func main() {
 
 
 	// set up callbacks for camera movement
-	window.SetKeyCallback(
-		func(_ *glfw.Window, key glfw.Key, _ int, action glfw.Action, _ glfw.ModifierKey) {
-			switch key {
-			case glfw.KeyA:
-				switch action {
-				case glfw.Press:
-					leftKeyPressed = true
-					leftKeyOn = true
-				case glfw.Release:
-					leftKeyOn = false
-				}
-			case glfw.KeyD:
-				switch action {
-				case glfw.Press:
-					rightKeyPressed = true
-					rightKeyOn = true
-				case glfw.Release:
-					rightKeyOn = false
-				}
-			case glfw.KeyW:
-				switch action {
-				case glfw.Press:
-					upKeyPressed = true
-					upKeyOn = true
-				case glfw.Release:
-					upKeyOn = false
-				}
-			case glfw.KeyS:
-				switch action {
-				case glfw.Press:
-					downKeyPressed = true
-					downKeyOn = true
-				case glfw.Release:
-					downKeyOn = false
+	if window != nil {
+		window.SetKeyCallback(
+			func(_ *glfw.Window, key glfw.Key, _ int, action glfw.Action, _ glfw.ModifierKey) {
+				switch key {
+				case glfw.KeyA:
+					switch action {
+					case glfw.Press:
+						leftKeyPressed = true
+						leftKeyOn = true
+					case glfw.Release:
+						leftKeyOn = false
+					}
+				case glfw.KeyD:
+					switch action {
+					case glfw.Press:
+						rightKeyPressed = true
+						rightKeyOn = true
+					case glfw.Release:
+						rightKeyOn = false
+					}
+				case glfw.KeyW:
+					switch action {
+					case glfw.Press:
+						upKeyPressed = true
+						upKeyOn = true
+					case glfw.Release:
+						upKeyOn = false
+					}
+				case glfw.KeyS:
+					switch action {
+					case glfw.Press:
+						downKeyPressed = true
+						downKeyOn = true
+					case glfw.Release:
+						downKeyOn = false
+					}
 				}
-			}
-		},
-	)
+			},
+		)
 
-	window.SetScrollCallback(
-		func(_ *glfw.Window, xOffset, yOffset float64) {
-			scrollDirection -= float32(yOffset)
-			scrolling = true
-		},
-	)
+		window.SetScrollCallback(
+			func(_ *glfw.Window, xOffset, yOffset float64) {
+				scrollDirection -= float32(yOffset)
+				scrolling = true
+			},
+		)
+	}
 
 
 	// set up coordinate axis render prerequisites
@@ -404,8 +425,8 @@ func main() {
 	// profiling loops
 	var localWorkGroupSize uint32
 	var globalWorkGroupSize uint32
-	for localWorkGroupSize = 32; localWorkGroupSize <= 1024 && !window.ShouldClose(); localWorkGroupSize *= 2 {
-		for numSpheres := 2; numSpheres <= 262144 && !window.ShouldClose(); numSpheres *= 2 {
+	for localWorkGroupSize = 32; localWorkGroupSize <= 1024 && !windowClosed(window); localWorkGroupSize *= 2 {
+		for numSpheres := 2; numSpheres <= 262144 && !windowClosed(window); numSpheres *= 2 {
 			globalWorkGroupSize = uint32(numSpheres) / localWorkGroupSize
 			if uint32(numSpheres) % localWorkGroupSize != 0 {
 				globalWorkGroupSize += 1
@@ -535,8 +556,8 @@ func main() {
 			var locationBuffer1Active bool
 			var progressBar string = ""
 			i := 0
-			for ; i < profilingLogLength && !window.ShouldClose(); i++ {
-				loopTimeStart = glfw.GetTime()
+			for ; i < profilingLogLength && !windowClosed(window); i++ {
+				loopTimeStart = seconds()
 
 
 				// FPS counter and progress bar, displays FPS every second
@@ -555,7 +576,9 @@ func main() {
 
 
 				// GLFW event handling
-				glfw.PollEvents()
+				if window != nil {
+					glfw.PollEvents()
+				}
 
 
 				// use compute shader to update sphere positions
@@ -653,30 +676,32 @@ func main() {
 				scrolling = false
 
 
-				// rendering
-				gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
-
-				gl.UseProgram(axisProgram)
-				gl.BindVertexArray(axisVertexArray)
-				gl.DrawArraysInstanced(gl.LINES, 0, 6, 1)
-				gl.BindVertexArray(0)
-				gl.UseProgram(0)
-
-				gl.UseProgram(sphereProgram)
-				gl.BindVertexArray(sphereVertexArray)
-				gl.BeginQuery(gl.TIME_ELAPSED, query)
-				gl.DrawElementsInstanced(gl.PATCHES, 24, gl.UNSIGNED_INT, nil, int32(numSpheres))
-				gl.EndQuery(gl.TIME_ELAPSED)
-				gl.BindVertexArray(0)
-				gl.UseProgram(0)
-				for {
-					gl.GetQueryObjectuiv(query, gl.QUERY_RESULT_AVAILABLE, &queryReady)
-					if queryReady == gl.TRUE {
-						break
+				// rendering, skipped in headless mode
+				if window != nil {
+					gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+
+					gl.UseProgram(axisProgram)
+					gl.BindVertexArray(axisVertexArray)
+					gl.DrawArraysInstanced(gl.LINES, 0, 6, 1)
+					gl.BindVertexArray(0)
+					gl.UseProgram(0)
+
+					gl.UseProgram(sphereProgram)
+					gl.BindVertexArray(sphereVertexArray)
+					gl.BeginQuery(gl.TIME_ELAPSED, query)
+					gl.DrawElementsInstanced(gl.PATCHES, 24, gl.UNSIGNED_INT, nil, int32(numSpheres))
+					gl.EndQuery(gl.TIME_ELAPSED)
+					gl.BindVertexArray(0)
+					gl.UseProgram(0)
+					for {
+						gl.GetQueryObjectuiv(query, gl.QUERY_RESULT_AVAILABLE, &queryReady)
+						if queryReady == gl.TRUE {
+							break
+						}
 					}
+					gl.GetQueryObjectui64v(query, gl.QUERY_RESULT, &queryDuration)
+					profilingLog.sphereRender += queryDuration
 				}
-				gl.GetQueryObjectui64v(query, gl.QUERY_RESULT, &queryDuration)
-				profilingLog.sphereRender += queryDuration
 
 				if locationBuffer1Active {
 					gl.BindBufferBase(gl.SHADER_STORAGE_BUFFER, 0, gravityBuffer0)
@@ -687,10 +712,12 @@ func main() {
 				}
 				locationBuffer1Active = !locationBuffer1Active
 
-				window.SwapBuffers()
+				if window != nil {
+					window.SwapBuffers()
+				}
 
 
-				loopTimeElapsed = glfw.GetTime() - loopTimeStart
+				loopTimeElapsed = seconds() - loopTimeStart
 			}
 			fmt.Println(fmt.Sprintf(
 				"[%-40s] %4d/%4d Frames; %4d AVG FPS\r",
@@ -723,6 +750,18 @@ func main() {
 }
 
 
+// windowClosed reports whether the user closed the window, a headless run has none to close
+func windowClosed(window *glfw.Window) bool {
+	return window != nil && window.ShouldClose()
+}
+
+
+// seconds since the start of the program, replaces GLFW's timer that needs an initialized GLFW
+func seconds() float64 {
+	return time.Since(startTime).Seconds()
+}
+
+
 func init() {
 	runtime.LockOSThread()
 	rand.Seed(time.Now().UnixNano())
@@ -0,0 +1,115 @@
+
+// Package recording renders frames into an offscreen framebuffer of a fixed resolution and writes them to disk as
+// PNG files or as a raw Y4M stream, independent of the window, its size and vsync, and also in headless runs.
+package recording
+
+
+import (
+	"fmt"
+	"image"
+	"strings"
+	"unsafe"
+
+	"github.com/go-gl/gl/v4.5-core/gl"
+)
+
+
+type frameWriter interface {
+	WriteFrame(frame *image.RGBA) error
+	Close() error
+}
+
+
+// Recorder owns a framebuffer object with a color and a depth buffer. Everything drawn between Begin and End ends
+// up in the next frame of the recording.
+type Recorder struct {
+	Width, Height int
+	Frames int		// frames written so far
+
+	framebuffer, colorBuffer, depthBuffer uint32
+	viewport [4]int32
+	frame *image.RGBA
+	output frameWriter
+}
+
+
+// NewRecorder records frames of width x height pixels into path. A path ending in ".y4m" is a single Y4M stream at
+// framesPerSecond, any other path is a directory that gets one PNG file per frame.
+func NewRecorder(path string, width, height, framesPerSecond int) (*Recorder, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("Invalid recording size %vx%v", width, height)
+	}
+
+	var output frameWriter
+	var err error
+	if strings.HasSuffix(path, ".y4m") {
+		output, err = newY4MWriter(path, width, height, framesPerSecond)
+	} else {
+		output, err = newPNGWriter(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{
+		Width: width,
+		Height: height,
+		frame: image.NewRGBA(image.Rect(0, 0, width, height)),
+		output: output,
+	}
+
+	gl.CreateRenderbuffers(1, &r.colorBuffer)
+	gl.NamedRenderbufferStorage(r.colorBuffer, gl.RGBA8, int32(width), int32(height))
+	gl.CreateRenderbuffers(1, &r.depthBuffer)
+	gl.NamedRenderbufferStorage(r.depthBuffer, gl.DEPTH_COMPONENT24, int32(width), int32(height))
+
+	gl.CreateFramebuffers(1, &r.framebuffer)
+	gl.NamedFramebufferRenderbuffer(r.framebuffer, gl.COLOR_ATTACHMENT0, gl.RENDERBUFFER, r.colorBuffer)
+	gl.NamedFramebufferRenderbuffer(r.framebuffer, gl.DEPTH_ATTACHMENT, gl.RENDERBUFFER, r.depthBuffer)
+	if status := gl.CheckNamedFramebufferStatus(r.framebuffer, gl.FRAMEBUFFER); status != gl.FRAMEBUFFER_COMPLETE {
+		r.Close()
+		return nil, fmt.Errorf("Incomplete recording framebuffer: status 0x%x", status)
+	}
+	return r, nil
+}
+
+
+// Begin redirects rendering into the recording framebuffer until End.
+func (r *Recorder) Begin() {
+	gl.GetIntegerv(gl.VIEWPORT, &r.viewport[0])
+	gl.BindFramebuffer(gl.FRAMEBUFFER, r.framebuffer)
+	gl.Viewport(0, 0, int32(r.Width), int32(r.Height))
+}
+
+
+// End reads the rendered frame back, restores the previous framebuffer and viewport and writes the frame.
+func (r *Recorder) End() error {
+	gl.PixelStorei(gl.PACK_ALIGNMENT, 1)
+	gl.ReadPixels(0, 0, int32(r.Width), int32(r.Height), gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&r.frame.Pix[0]))
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	gl.Viewport(r.viewport[0], r.viewport[1], r.viewport[2], r.viewport[3])
+
+	// OpenGL starts at the bottom row, images at the top one
+	stride := r.frame.Stride
+	row := make([]byte, stride)
+	for top, bottom := 0, r.Height - 1; top < bottom; top, bottom = top + 1, bottom - 1 {
+		copy(row, r.frame.Pix[top * stride:(top + 1) * stride])
+		copy(r.frame.Pix[top * stride:(top + 1) * stride], r.frame.Pix[bottom * stride:(bottom + 1) * stride])
+		copy(r.frame.Pix[bottom * stride:(bottom + 1) * stride], row)
+	}
+
+	if err := r.output.WriteFrame(r.frame); err != nil {
+		return err
+	}
+	r.Frames += 1
+	return nil
+}
+
+
+// Close deletes the framebuffer and finishes the recording.
+func (r *Recorder) Close() error {
+	gl.DeleteFramebuffers(1, &r.framebuffer)
+	gl.DeleteRenderbuffers(1, &r.depthBuffer)
+	gl.DeleteRenderbuffers(1, &r.colorBuffer)
+	return r.output.Close()
+}
@@ -0,0 +1,119 @@
+
+package recording
+
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+)
+
+
+// pngWriter writes every frame into a PNG file of its own, numbered from 0
+type pngWriter struct {
+	directory string
+	frames int
+}
+
+
+func newPNGWriter(directory string) (*pngWriter, error) {
+	if err := os.MkdirAll(directory, 0777); err != nil {
+		return nil, fmt.Errorf("Could not create '%s': %s", directory, err)
+	}
+	return &pngWriter{directory: directory}, nil
+}
+
+
+func (w *pngWriter) WriteFrame(frame *image.RGBA) error {
+	fileName := filepath.Join(w.directory, fmt.Sprintf("frame%06d.png", w.frames))
+	file, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("Could not create '%s': %s", fileName, err)
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, frame); err != nil {
+		return fmt.Errorf("Could not write '%s': %s", fileName, err)
+	}
+	w.frames += 1
+	return file.Close()
+}
+
+
+func (w *pngWriter) Close() error {
+	return nil
+}
+
+
+// y4mWriter writes all frames into one uncompressed YUV4MPEG2 stream with 4:2:0 chroma subsampling, which ffmpeg and
+// most players read directly
+type y4mWriter struct {
+	file *os.File
+	buffer *bufio.Writer
+	y, cb, cr []byte
+}
+
+
+func newY4MWriter(fileName string, width, height, framesPerSecond int) (*y4mWriter, error) {
+	if width % 2 != 0 || height % 2 != 0 {
+		return nil, fmt.Errorf("Y4M recordings need an even width and height, not %vx%v", width, height)
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create '%s': %s", fileName, err)
+	}
+	w := &y4mWriter{
+		file: file,
+		buffer: bufio.NewWriter(file),
+		y: make([]byte, width * height),
+		cb: make([]byte, width * height / 4),
+		cr: make([]byte, width * height / 4),
+	}
+	if _, err := fmt.Fprintf(w.buffer, "YUV4MPEG2 W%d H%d F%d:1 Ip A1:1 C420jpeg\n", width, height, framesPerSecond); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Could not write '%s': %s", fileName, err)
+	}
+	return w, nil
+}
+
+
+// WriteFrame converts the frame to limited range BT.601, the chroma of each 2x2 block is the mean of its pixels
+func (w *y4mWriter) WriteFrame(frame *image.RGBA) error {
+	width, height := frame.Rect.Dx(), frame.Rect.Dy()
+	for row := 0; row < height; row += 2 {
+		for column := 0; column < width; column += 2 {
+			var sumR, sumG, sumB float64
+			for _, p := range [4][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				x, y := column + p[0], row + p[1]
+				offset := y * frame.Stride + 4 * x
+				r, g, b := float64(frame.Pix[offset]), float64(frame.Pix[offset + 1]), float64(frame.Pix[offset + 2])
+				w.y[y * width + x] = byte(16.5 + (65.738 * r + 129.057 * g + 25.064 * b) / 256)
+				sumR, sumG, sumB = sumR + r, sumG + g, sumB + b
+			}
+			r, g, b := sumR / 4, sumG / 4, sumB / 4
+			chroma := row / 2 * width / 2 + column / 2
+			w.cb[chroma] = byte(128.5 + (-37.945 * r - 74.494 * g + 112.439 * b) / 256)
+			w.cr[chroma] = byte(128.5 + (112.439 * r - 94.154 * g - 18.285 * b) / 256)
+		}
+	}
+
+	for _, data := range [][]byte{[]byte("FRAME\n"), w.y, w.cb, w.cr} {
+		if _, err := w.buffer.Write(data); err != nil {
+			return fmt.Errorf("Could not write '%s': %s", w.file.Name(), err)
+		}
+	}
+	return nil
+}
+
+
+func (w *y4mWriter) Close() error {
+	if err := w.buffer.Flush(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("Could not write '%s': %s", w.file.Name(), err)
+	}
+	return w.file.Close()
+}